@@ -0,0 +1,24 @@
+// Package backplane fans hub events out across backend replicas so a user
+// connected to one replica receives events produced on another.
+package backplane
+
+import (
+	"context"
+
+	"github.com/Adisonsmn/ngobrolyuk/models"
+)
+
+// Message is a hub event addressed to every device of UserIDs on any replica
+type Message struct {
+	Origin  string       `json:"origin"` // Replica that published the message
+	UserIDs []string     `json:"user_ids"`
+	Event   models.Event `json:"event"`
+}
+
+type Backplane interface {
+	// Publish hands msg to every other replica. The publishing replica delivers to its own clients itself.
+	Publish(ctx context.Context, msg Message) error
+	// Subscribe calls handle for every message published by a replica other than origin.
+	// It blocks until ctx is cancelled.
+	Subscribe(ctx context.Context, origin string, handle func(Message)) error
+}
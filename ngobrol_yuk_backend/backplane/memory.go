@@ -0,0 +1,49 @@
+package backplane
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory connects hubs living in the same process. With a single backend
+// replica nothing ever needs to be forwarded, which makes it the default.
+type Memory struct {
+	mu          sync.RWMutex
+	subscribers map[*memorySubscriber]bool
+}
+
+type memorySubscriber struct {
+	origin string
+	handle func(Message)
+}
+
+func NewMemory() *Memory {
+	return &Memory{subscribers: make(map[*memorySubscriber]bool)}
+}
+
+func (m *Memory) Publish(ctx context.Context, msg Message) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for sub := range m.subscribers {
+		if sub.origin != msg.Origin {
+			sub.handle(msg)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, origin string, handle func(Message)) error {
+	sub := &memorySubscriber{origin: origin, handle: handle}
+
+	m.mu.Lock()
+	m.subscribers[sub] = true
+	m.mu.Unlock()
+
+	<-ctx.Done()
+
+	m.mu.Lock()
+	delete(m.subscribers, sub)
+	m.mu.Unlock()
+	return ctx.Err()
+}
@@ -0,0 +1,114 @@
+package backplane
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventsCollection holds published messages; a TTL index removes them after a minute
+const EventsCollection = "hub_events"
+
+// Mongo routes messages through a collection that every replica tails with a
+// change stream. Change streams need MongoDB to run as a replica set.
+type Mongo struct {
+	collection *mongo.Collection
+}
+
+type mongoEvent struct {
+	Origin    string    `bson:"origin"`
+	UserIDs   []string  `bson:"user_ids"`
+	Event     string    `bson:"event"` // JSON-encoded models.Event
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+func NewMongo(db *mongo.Database) *Mongo {
+	return &Mongo{collection: db.Collection(EventsCollection)}
+}
+
+func (m *Mongo) Publish(ctx context.Context, msg Message) error {
+	raw, err := json.Marshal(msg.Event)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.collection.InsertOne(ctx, mongoEvent{
+		Origin:    msg.Origin,
+		UserIDs:   msg.UserIDs,
+		Event:     string(raw),
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+// Subscribe tails inserts and resumes after transient failures from the last seen token
+func (m *Mongo) Subscribe(ctx context.Context, origin string, handle func(Message)) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"operationType":       "insert",
+			"fullDocument.origin": bson.M{"$ne": origin},
+		}}},
+	}
+
+	var resumeToken bson.Raw
+	backoff := time.Second
+
+	for {
+		opts := options.ChangeStream()
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+
+		stream, err := m.collection.Watch(ctx, pipeline, opts)
+		if err == nil {
+			backoff = time.Second
+			for stream.Next(ctx) {
+				resumeToken = stream.ResumeToken()
+
+				var change struct {
+					FullDocument mongoEvent `bson:"fullDocument"`
+				}
+				if err := stream.Decode(&change); err != nil {
+					log.Printf("Backplane: failed to decode change: %v", err)
+					continue
+				}
+
+				msg := Message{
+					Origin:  change.FullDocument.Origin,
+					UserIDs: change.FullDocument.UserIDs,
+				}
+				if err := json.Unmarshal([]byte(change.FullDocument.Event), &msg.Event); err != nil {
+					log.Printf("Backplane: failed to decode event: %v", err)
+					continue
+				}
+				handle(msg)
+			}
+			err = stream.Err()
+			stream.Close(context.Background())
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// An expired resume token cannot be used again, start from now instead
+		if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.HasErrorLabel("NonResumableChangeStreamError") {
+			resumeToken = nil
+		}
+
+		log.Printf("Backplane: change stream interrupted, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
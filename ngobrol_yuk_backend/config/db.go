@@ -82,6 +82,7 @@ func createIndexes(db *mongo.Database) error {
 
 	userCollection := db.Collection("users")
 	messageCollection := db.Collection("messages")
+	conversationCollection := db.Collection("conversations")
 
 	// ✅ Indexes untuk users
 	userIndexes := []mongo.IndexModel{
@@ -96,6 +97,9 @@ func createIndexes(db *mongo.Database) error {
 		{
 			Keys: bson.D{{Key: "online", Value: 1}, {Key: "last_seen", Value: -1}},
 		},
+		{
+			Keys: bson.D{{Key: "online", Value: 1}, {Key: "last_active", Value: 1}},
+		},
 	}
 	if _, err := userCollection.Indexes().CreateMany(ctx, userIndexes); err != nil {
 		log.Printf("Failed to create user indexes: %v", err)
@@ -120,11 +124,194 @@ func createIndexes(db *mongo.Database) error {
 		{
 			Keys: bson.D{{Key: "created_at", Value: -1}},
 		},
+		{
+			// Resync after reconnect scans incoming messages by time
+			Keys: bson.D{
+				{Key: "receiver_id", Value: 1},
+				{Key: "created_at", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "conversation_id", Value: 1},
+				{Key: "created_at", Value: -1},
+			},
+		},
+		{
+			// Retries with the same client_msg_id never create a second message
+			Keys: bson.D{
+				{Key: "sender_id", Value: 1},
+				{Key: "client_msg_id", Value: 1},
+			},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"client_msg_id": bson.M{"$type": "string"}}),
+		},
+		{
+			// Full-text search; "none" skips stemming and stop words since chats mix languages
+			Keys:    bson.D{{Key: "content", Value: "text"}},
+			Options: options.Index().SetDefaultLanguage("none"),
+		},
+		{
+			// Media downloads look up the messages that share an attachment
+			Keys:    bson.D{{Key: "attachment.id", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"attachment.id": bson.M{"$exists": true}}),
+		},
 	}
 	if _, err := messageCollection.Indexes().CreateMany(ctx, messageIndexes); err != nil {
 		log.Printf("Failed to create message indexes: %v", err)
 		return err
 	}
 
+	// ✅ Indexes untuk conversations (group chats)
+	conversationIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "members.user_id", Value: 1},
+				{Key: "updated_at", Value: -1},
+			},
+		},
+	}
+	if _, err := conversationCollection.Indexes().CreateMany(ctx, conversationIndexes); err != nil {
+		log.Printf("Failed to create conversation indexes: %v", err)
+		return err
+	}
+
+	// ✅ Indexes untuk conversation settings (per user, per direct chat or group)
+	conversationSettingsIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "user_id", Value: 1},
+				{Key: "peer_id", Value: 1},
+			},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"peer_id": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys: bson.D{
+				{Key: "user_id", Value: 1},
+				{Key: "conversation_id", Value: 1},
+			},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"conversation_id": bson.M{"$type": "objectId"}}),
+		},
+	}
+	if _, err := db.Collection("conversation_settings").Indexes().CreateMany(ctx, conversationSettingsIndexes); err != nil {
+		log.Printf("Failed to create conversation settings indexes: %v", err)
+		return err
+	}
+
+	// ✅ Indexes untuk sessions (refresh token families)
+	sessionIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			// Reuse detection looks up tokens that were already rotated
+			Keys: bson.D{{Key: "previous_hashes", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+		{
+			// Sessions that were not refreshed in time are removed automatically
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	if _, err := db.Collection("sessions").Indexes().CreateMany(ctx, sessionIndexes); err != nil {
+		log.Printf("Failed to create session indexes: %v", err)
+		return err
+	}
+
+	// ✅ Indexes untuk token_denylist (access token yang dicabut sebelum expired)
+	denylistIndexes := []mongo.IndexModel{
+		{
+			// Replicas poll for entries added since their last sync
+			Keys: bson.D{{Key: "created_at", Value: 1}},
+		},
+		{
+			// Entries are useless once the tokens they cover have expired
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	if _, err := db.Collection("token_denylist").Indexes().CreateMany(ctx, denylistIndexes); err != nil {
+		log.Printf("Failed to create token denylist indexes: %v", err)
+		return err
+	}
+
+	// ✅ Indexes untuk contacts, satu dokumen per pasangan user
+	contactIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "pair", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "requester_id", Value: 1},
+				{Key: "status", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "addressee_id", Value: 1},
+				{Key: "status", Value: 1},
+			},
+		},
+	}
+	if _, err := db.Collection("contacts").Indexes().CreateMany(ctx, contactIndexes); err != nil {
+		log.Printf("Failed to create contact indexes: %v", err)
+		return err
+	}
+
+	// ✅ Indexes untuk block list dan report
+	blockIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "blocker_id", Value: 1},
+				{Key: "blocked_id", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "blocked_id", Value: 1}},
+		},
+	}
+	if _, err := db.Collection("blocks").Indexes().CreateMany(ctx, blockIndexes); err != nil {
+		log.Printf("Failed to create block indexes: %v", err)
+		return err
+	}
+
+	reportIndexes := []mongo.IndexModel{
+		{
+			// Moderators work through open reports oldest first
+			Keys: bson.D{
+				{Key: "status", Value: 1},
+				{Key: "created_at", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{{Key: "reported_id", Value: 1}},
+		},
+	}
+	if _, err := db.Collection("reports").Indexes().CreateMany(ctx, reportIndexes); err != nil {
+		log.Printf("Failed to create report indexes: %v", err)
+		return err
+	}
+
+	// ✅ TTL index untuk backplane events antar replica
+	hubEventIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(60),
+	}
+	if _, err := db.Collection("hub_events").Indexes().CreateOne(ctx, hubEventIndex); err != nil {
+		log.Printf("Failed to create hub event indexes: %v", err)
+		return err
+	}
+
 	return nil
 }
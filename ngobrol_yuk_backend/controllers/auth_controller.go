@@ -2,15 +2,19 @@ package controllers
 
 import (
 	"context"
+	"log"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/denylist"
+	"github.com/Adisonsmn/ngobrolyuk/jwtkeys"
 	"github.com/Adisonsmn/ngobrolyuk/models"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -89,17 +93,15 @@ func Register(c *fiber.Ctx) error {
 		})
 	}
 
-	// Generate JWT token
-	token, err := generateJWT(user.ID)
+	// Start a session: short-lived access token plus rotating refresh token, both as HTTP-only cookies
+	tokens, err := startSession(c, user.ID)
 	if err != nil {
+		log.Printf("Failed to start session for user %s: %v", user.ID, err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to generate token",
 		})
 	}
 
-	// Set HTTP-only cookie
-	setJWTCookie(c, token)
-
 	// Return user info (without password)
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"message": "Registration successful",
@@ -110,6 +112,7 @@ func Register(c *fiber.Ctx) error {
 			"bio":      user.Bio,
 			"avatar":   user.Avatar,
 		},
+		"tokens": tokens,
 	})
 }
 func Login(c *fiber.Ctx) error {
@@ -154,17 +157,15 @@ func Login(c *fiber.Ctx) error {
 		bson.M{"$set": bson.M{"last_seen": time.Now()}},
 	)
 
-	// Generate JWT token
-	token, err := generateJWT(user.ID)
+	// Start a session: short-lived access token plus rotating refresh token, both as HTTP-only cookies
+	tokens, err := startSession(c, user.ID)
 	if err != nil {
+		log.Printf("Failed to start session for user %s: %v", user.ID, err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to generate token",
 		})
 	}
 
-	// Set HTTP-only cookie
-	setJWTCookie(c, token)
-
 	// Return user info
 	return c.JSON(fiber.Map{
 		"message": "Login successful",
@@ -175,14 +176,41 @@ func Login(c *fiber.Ctx) error {
 			"bio":      user.Bio,
 			"avatar":   user.Avatar,
 		},
+		"tokens": tokens,
 	})
 }
 
 func Logout(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(string)
+	sessionID := c.Locals("session_id").(primitive.ObjectID)
+	tokenID := c.Locals("token_id").(string)
+	exp := c.Locals("jwt_exp").(float64)
+
+	// Revoke the session so its refresh token and access tokens can no longer be used.
+	// When this fails the session is either untouched, so the logout can be retried, or its tokens
+	// are denied already and the next refresh finishes the revocation.
+	if _, err := revokeSession(context.Background(), sessionID, userID, models.SessionRevokedLogout); err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID.Hex(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to revoke session",
+		})
+	}
+
+	// The session entry only lasts the configured access token TTL, the token's own entry
+	// lasts until it expires. A failure is only logged, the session entry covers it meanwhile.
+	err := denylist.Add(context.Background(), denylist.Entry{
+		ID:        tokenID,
+		Kind:      denylist.KindToken,
+		UserID:    userID,
+		Reason:    models.SessionRevokedLogout,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	})
+	if err != nil {
+		log.Printf("Failed to deny token of session %s: %v", sessionID.Hex(), err)
+	}
 
 	// Set user offline
-	_, err := config.DB.Collection("users").UpdateOne(
+	_, err = config.DB.Collection("users").UpdateOne(
 		context.Background(),
 		bson.M{"_id": userID},
 		bson.M{"$set": bson.M{"online": false, "last_seen": time.Now()}},
@@ -194,71 +222,120 @@ func Logout(c *fiber.Ctx) error {
 	}
 
 	// Clear cookie dengan cara overwrite dan expired
-	clearJWTCookie(c)
+	clearAuthCookies(c)
 
 	return c.JSON(fiber.Map{
 		"message": "Logged out successfully",
 	})
 }
+
+// RefreshToken trades a refresh token (cookie or body) for a new access and refresh token.
+// It does not require a valid access token, the old one has usually expired already.
 func RefreshToken(c *fiber.Ctx) error {
-	userID := c.Locals("user_id").(string)
+	token := c.Cookies(refreshCookieName)
+	if token == "" {
+		var input models.RefreshRequest
+		if err := c.BodyParser(&input); err == nil {
+			token = input.RefreshToken
+		}
+	}
 
-	// Generate new token
-	token, err := generateJWT(userID)
-	if err != nil {
+	if token == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Missing refresh token",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, refreshToken, err := rotateSession(ctx, token)
+	switch err {
+	case nil:
+	case errRefreshTokenReused:
+		clearAuthCookies(c)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Refresh token already used, all tokens of this session were revoked",
+		})
+	case errInvalidRefreshToken:
+		clearAuthCookies(c)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired refresh token",
+		})
+	default:
+		log.Printf("Failed to rotate refresh token: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to refresh token",
 		})
 	}
 
-	// Set new cookie
-	setJWTCookie(c, token)
+	tokens, err := issueTokens(c, session, refreshToken)
+	if err != nil {
+		log.Printf("Failed to issue tokens for session %s: %v", session.ID.Hex(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh token",
+		})
+	}
 
 	return c.JSON(fiber.Map{
 		"message": "Token refreshed successfully",
+		"tokens":  tokens,
 	})
 }
 
 // Helper functions
-func generateJWT(userID string) (string, error) {
+func generateJWT(userID, sessionID string, expiresAt time.Time) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 72).Unix(),
+		"sid":     sessionID,
+		"jti":     primitive.NewObjectID().Hex(), // Lets a single token be denied before it expires
+		"exp":     expiresAt.Unix(),
 		"iat":     time.Now().Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	// RS256/EdDSA with a kid header when JWT_KEYS_DIR is set, HS256 with JWT_SECRET otherwise
+	return jwtkeys.Sign(claims)
+}
+
+// GetJWKS publishes the public keys access tokens are signed with, for other services to verify them
+func GetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(jwtkeys.PublicJWKS())
 }
 
-func setJWTCookie(c *fiber.Ctx, token string) {
-	sameSite := fiber.CookieSameSiteStrictMode
+func cookieSameSite() string {
 	if os.Getenv("ENVIRONMENT") != "production" {
-		sameSite = fiber.CookieSameSiteNoneMode
+		return fiber.CookieSameSiteNoneMode
 	}
+	return fiber.CookieSameSiteStrictMode
+}
+
+func setJWTCookie(c *fiber.Ctx, token string, expiresAt time.Time) {
 	c.Cookie(&fiber.Cookie{
 		Name:     "jwt",
 		Value:    token,
-		Expires:  time.Now().Add(time.Hour * 72),
+		Expires:  expiresAt,
 		HTTPOnly: true,
 		Secure:   true,
-		SameSite: sameSite,
+		SameSite: cookieSameSite(),
 		Path:     "/",
 	})
 }
 
-func clearJWTCookie(c *fiber.Ctx) {
-	sameSite := fiber.CookieSameSiteStrictMode
-	if os.Getenv("ENVIRONMENT") != "production" {
-		sameSite = fiber.CookieSameSiteNoneMode
-	}
+// setRefreshCookie scopes the refresh token to the auth routes, it is never sent with other requests
+func setRefreshCookie(c *fiber.Ctx, token string, expiresAt time.Time) {
 	c.Cookie(&fiber.Cookie{
-		Name:     "jwt",
-		Value:    "",
-		Expires:  time.Now().Add(-time.Hour),
+		Name:     refreshCookieName,
+		Value:    token,
+		Expires:  expiresAt,
 		HTTPOnly: true,
 		Secure:   true,
-		SameSite: sameSite,
-		Path:     "/",
+		SameSite: cookieSameSite(),
+		Path:     refreshCookiePath,
 	})
 }
+
+func clearAuthCookies(c *fiber.Ctx) {
+	setJWTCookie(c, "", time.Now().Add(-time.Hour))
+	setRefreshCookie(c, "", time.Now().Add(-time.Hour))
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// isBlocked reports whether either user blocked the other, a block works in both directions
+func isBlocked(ctx context.Context, userID, otherID string) (bool, error) {
+	count, err := config.DB.Collection("blocks").CountDocuments(ctx, bson.M{
+		"$or": []bson.M{
+			{"blocker_id": userID, "blocked_id": otherID},
+			{"blocker_id": otherID, "blocked_id": userID},
+		},
+	}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// hasBlocked reports whether blockerID blocked blockedID, in that direction only
+func hasBlocked(ctx context.Context, blockerID, blockedID string) (bool, error) {
+	count, err := config.DB.Collection("blocks").CountDocuments(ctx,
+		bson.M{"blocker_id": blockerID, "blocked_id": blockedID},
+		options.Count().SetLimit(1),
+	)
+	return count > 0, err
+}
+
+// blockedUserIDs returns everyone userID blocked or was blocked by
+func blockedUserIDs(ctx context.Context, userID string) ([]string, error) {
+	cursor, err := config.DB.Collection("blocks").Find(ctx, bson.M{
+		"$or": []bson.M{
+			{"blocker_id": userID},
+			{"blocked_id": userID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []models.Block
+	if err := cursor.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		if b.BlockerID == userID {
+			ids = append(ids, b.BlockedID)
+		} else {
+			ids = append(ids, b.BlockerID)
+		}
+	}
+	return ids, nil
+}
+
+// blockUser is idempotent, blocking someone twice keeps the first block.
+// Any contact or pending contact request between both users is removed.
+func blockUser(ctx context.Context, blockerID, blockedID string) error {
+	_, err := config.DB.Collection("blocks").UpdateOne(ctx,
+		bson.M{"blocker_id": blockerID, "blocked_id": blockedID},
+		bson.M{"$setOnInsert": bson.M{
+			"blocker_id": blockerID,
+			"blocked_id": blockedID,
+			"created_at": time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = config.DB.Collection("contacts").DeleteOne(ctx,
+		bson.M{"pair": models.ContactPair(blockerID, blockedID)},
+	)
+	return err
+}
+
+func BlockUser(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	targetID := c.Params("id")
+
+	if targetID == currentUserID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot block yourself",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	missing, err := usersExist(ctx, []string{targetID})
+	if err != nil {
+		log.Printf("Failed to check blocked user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to block user",
+		})
+	}
+	if missing != "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if err := blockUser(ctx, currentUserID, targetID); err != nil {
+		log.Printf("Failed to block user %s for %s: %v", targetID, currentUserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to block user",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "User blocked",
+	})
+}
+
+func UnblockUser(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := config.DB.Collection("blocks").DeleteOne(ctx,
+		bson.M{"blocker_id": currentUserID, "blocked_id": c.Params("id")},
+	)
+	if err != nil {
+		log.Printf("Failed to unblock user for %s: %v", currentUserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unblock user",
+		})
+	}
+
+	if result.DeletedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User is not blocked",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "User unblocked",
+	})
+}
+
+// ListBlockedUsers only lists the users the caller blocked, never who blocked the caller
+func ListBlockedUsers(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := config.DB.Collection("blocks").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"blocker_id": currentUserID}},
+		{"$sort": bson.M{"created_at": -1}},
+		{"$lookup": bson.M{
+			"from": "users",
+			"let":  bson.M{"user_id": "$blocked_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$_id", "$$user_id"}}}},
+				{"$project": bson.M{"username": 1, "avatar": 1}},
+			},
+			"as": "user",
+		}},
+		{"$unwind": "$user"},
+	})
+	if err != nil {
+		log.Printf("Failed to fetch blocked users: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch blocked users",
+		})
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		CreatedAt time.Time   `bson:"created_at"`
+		User      models.User `bson:"user"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		log.Printf("Failed to decode blocked users: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch blocked users",
+		})
+	}
+
+	users := make([]fiber.Map, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, fiber.Map{
+			"id":         row.User.ID,
+			"username":   row.User.Username,
+			"avatar":     row.User.Avatar,
+			"blocked_at": row.CreatedAt,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"blocked_users": users,
+		"total":         len(users),
+	})
+}
@@ -3,45 +3,108 @@ package controllers
 import (
 	"context"
 	"log"
-	"os"
 	"sync"
 	"time"
 
+	"github.com/Adisonsmn/ngobrolyuk/backplane"
 	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/denylist"
 	"github.com/Adisonsmn/ngobrolyuk/models"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Client struct {
-	Conn   *websocket.Conn
-	UserID string
-	Send   chan models.Message
+	Conn      *websocket.Conn
+	UserID    string
+	SessionID primitive.ObjectID // Login session the device authenticated with
+	TokenID   string             // jti of the access token used for the upgrade
+	Send      chan models.Event
+
+	// While syncing, live events are held back until the resync has been streamed.
+	// Both fields are guarded by hub.mu.
+	syncing bool
+	pending []models.Event
+}
+
+// Delivery is an event addressed to every device of UserIDs, or to a single Client.
+// EndSync on a direct delivery flushes the events held back during a resync.
+type Delivery struct {
+	UserIDs []string
+	Client  *Client
+	Event   models.Event
+	EndSync bool
+
+	fromPeer bool // Already published to the backplane, by another replica or by the sender
 }
 
 type Hub struct {
-	Clients     map[string]*Client
+	Clients     map[string]map[*Client]bool // One entry per connected device
 	Register    chan *Client
 	Unregister  chan *Client
-	Broadcast   chan models.Message
+	Broadcast   chan Delivery
 	Connections int
 	mu          sync.RWMutex
+
+	// Deliveries for users are also forwarded to other replicas through the backplane
+	backplane backplane.Backplane
+	outbound  chan backplane.Message
 }
 
+// replicaID identifies this process on the backplane and in presence bookkeeping
+var replicaID = primitive.NewObjectID().Hex()
+
 var hub = &Hub{
-	Clients:     make(map[string]*Client),
+	Clients:     make(map[string]map[*Client]bool),
 	Register:    make(chan *Client),
 	Unregister:  make(chan *Client),
-	Broadcast:   make(chan models.Message, 1000), // Buffer untuk broadcast
+	Broadcast:   make(chan Delivery, 1000), // Buffer untuk broadcast
 	Connections: 0,
+	outbound:    make(chan backplane.Message, 1000),
 }
 
 func init() {
 	go hub.run()
+	go hub.heartbeat()
+}
+
+// UseBackplane switches the hub to bp and starts forwarding events between replicas.
+// It must be called once at startup, before the server accepts connections.
+func UseBackplane(ctx context.Context, bp backplane.Backplane) {
+	hub.mu.Lock()
+	hub.backplane = bp
+	hub.mu.Unlock()
+
+	go hub.publish(ctx)
+	go func() {
+		err := bp.Subscribe(ctx, replicaID, func(msg backplane.Message) {
+			hub.Broadcast <- Delivery{UserIDs: msg.UserIDs, Event: msg.Event, fromPeer: true}
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Backplane subscription stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Hub replica %s using %T backplane", replicaID, bp)
+}
+
+// publish forwards local deliveries to other replicas in order
+func (h *Hub) publish(ctx context.Context) {
+	for {
+		select {
+		case msg := <-h.outbound:
+			pubCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			if err := h.backplane.Publish(pubCtx, msg); err != nil {
+				log.Printf("Failed to publish %s event to backplane: %v", msg.Event.Type, err)
+			}
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 func (h *Hub) run() {
@@ -57,160 +120,195 @@ func (h *Hub) run() {
 		select {
 		case client := <-h.Register:
 			h.mu.Lock()
-			h.Clients[client.UserID] = client
+			devices, ok := h.Clients[client.UserID]
+			if !ok {
+				devices = make(map[*Client]bool)
+				h.Clients[client.UserID] = devices
+			}
+			devices[client] = true
 			h.Connections++
+			firstDevice := len(devices) == 1
 			h.mu.Unlock()
 
-			log.Printf("User %s connected. Total connections: %d", client.UserID, h.Connections)
+			log.Printf("User %s connected (%d devices). Total connections: %d", client.UserID, len(devices), h.Connections)
 
 			// Set user online dengan error handling
-			go func(userID string) {
-				_, err := config.DB.Collection("users").UpdateOne(context.Background(),
-					bson.M{"_id": userID},
-					bson.M{"$set": bson.M{"online": true, "last_seen": time.Now()}},
-				)
-				if err != nil {
-					log.Printf("Failed to set user %s online: %v", userID, err)
-				}
-			}(client.UserID)
+			if firstDevice {
+				go setUserOnline(client.UserID, true)
+			}
 
 		case client := <-h.Unregister:
 			h.mu.Lock()
-			if _, ok := h.Clients[client.UserID]; ok {
-				delete(h.Clients, client.UserID)
-				h.Connections--
-				close(client.Send)
-				log.Printf("User %s disconnected. Total connections: %d", client.UserID, h.Connections)
-			}
+			wentOffline := h.removeClient(client)
 			h.mu.Unlock()
 
-			// Set user offline dengan error handling
-			go func(userID string) {
-				_, err := config.DB.Collection("users").UpdateOne(context.Background(),
-					bson.M{"_id": userID},
-					bson.M{"$set": bson.M{"online": false, "last_seen": time.Now()}},
-				)
-				if err != nil {
-					log.Printf("Failed to set user %s offline: %v", userID, err)
-				}
-			}(client.UserID)
+			// Only the last device going away marks the user offline
+			if wentOffline {
+				go setUserOnline(client.UserID, false)
+			}
 
-		case message := <-h.Broadcast:
+		case delivery := <-h.Broadcast:
 			h.mu.Lock()
-			log.Printf("Processing broadcast message: %s -> %s", message.SenderID, message.ReceiverID)
+			var offline []string
+
+			if client := delivery.Client; client != nil && h.Clients[client.UserID][client] {
+				// Direct reply, only if that device is still registered
+				online := h.deliver(client, delivery.Event)
+
+				if online && delivery.EndSync {
+					client.syncing = false
+					for _, evt := range client.pending {
+						if online = h.deliver(client, evt); !online {
+							break
+						}
+					}
+					client.pending = nil
+				}
 
-			// Send to receiver
-			if receiverClient, ok := h.Clients[message.ReceiverID]; ok {
-				select {
-				case receiverClient.Send <- message:
-					log.Printf("Message sent to receiver: %s", message.ReceiverID)
-				default:
-					// Handle full channel
-					delete(h.Clients, message.ReceiverID)
-					close(receiverClient.Send)
-					h.Connections--
-					log.Printf("Receiver channel full, disconnected user: %s", message.ReceiverID)
+				if !online {
+					offline = append(offline, client.UserID)
 				}
-			} else {
-				log.Printf("Receiver %s not connected", message.ReceiverID)
 			}
 
-			// Send to sender (for confirmation)
-			if senderClient, ok := h.Clients[message.SenderID]; ok {
+			if h.backplane != nil && len(delivery.UserIDs) > 0 && !delivery.fromPeer {
 				select {
-				case senderClient.Send <- message:
-					log.Printf("Message confirmation sent to sender: %s", message.SenderID)
+				case h.outbound <- backplane.Message{Origin: replicaID, UserIDs: delivery.UserIDs, Event: delivery.Event}:
 				default:
-					delete(h.Clients, message.SenderID)
-					close(senderClient.Send)
-					h.Connections--
-					log.Printf("Sender channel full, disconnected user: %s", message.SenderID)
+					log.Printf("Backplane queue full, %s event not forwarded to other replicas", delivery.Event.Type)
+				}
+			}
+
+			revoked, revoking := revokedSession(delivery.Event)
+
+			for _, userID := range delivery.UserIDs {
+				devices, ok := h.Clients[userID]
+				if !ok {
+					continue
+				}
+
+				for client := range devices {
+					// Only devices of the revoked session get the event, it is their last one
+					if revoking {
+						if client.SessionID == revoked {
+							log.Printf("Session %s revoked, disconnecting a device of user: %s", revoked.Hex(), userID)
+							if !h.deliver(client, delivery.Event) || h.removeClient(client) {
+								offline = append(offline, userID)
+							}
+						}
+						continue
+					}
+					if client.syncing {
+						if !h.hold(client, delivery.Event) {
+							offline = append(offline, userID)
+						}
+						continue
+					}
+					if !h.deliver(client, delivery.Event) {
+						offline = append(offline, userID)
+					}
 				}
-			} else {
-				log.Printf("Sender %s not connected during broadcast", message.SenderID)
 			}
 			h.mu.Unlock()
+
+			for _, userID := range offline {
+				go setUserOnline(userID, false)
+			}
 		}
 	}
 }
 
-func TestWebSocketChat(c *websocket.Conn) {
-	// Get token from query param
-	tokenStr := c.Cookies("jwt")
-	if tokenStr == "" {
-		log.Printf("WebSocket connection rejected: no token provided")
-		c.Close()
-		return
+// revokedSession returns the session a session_revoked event is about
+func revokedSession(evt models.Event) (primitive.ObjectID, bool) {
+	if evt.Type != models.EventSessionRevoked {
+		return primitive.NilObjectID, false
 	}
 
-	// Parse and validate token
-	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-
-	if err != nil || !token.Valid {
-		log.Printf("WebSocket connection rejected: invalid token - %v", err)
-		c.Close()
-		return
+	var payload models.SessionRevokedPayload
+	if err := evt.DecodePayload(&payload); err != nil {
+		log.Printf("Invalid %s payload: %v", evt.Type, err)
+		return primitive.NilObjectID, false
 	}
+	return payload.SessionID, true
+}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		log.Printf("WebSocket connection rejected: invalid token claims")
-		c.Close()
-		return
+// deliver queues evt on a device and drops the device if its buffer is full.
+// It returns false only when that was the user's last device. Callers must hold h.mu.
+func (h *Hub) deliver(client *Client, evt models.Event) bool {
+	select {
+	case client.Send <- evt:
+		log.Printf("Event %s sent to user: %s", evt.Type, client.UserID)
+		return true
+	default:
+		// Handle full channel, only this device is dropped
+		log.Printf("Channel full, disconnecting a device of user: %s", client.UserID)
+		return !h.removeClient(client)
 	}
+}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok || userID == "" {
-		log.Printf("WebSocket connection rejected: invalid user_id in token")
-		c.Close()
-		return
+// hold parks a live event for a syncing device, dropping the device if too much piles up.
+// It returns false only when that was the user's last device. Callers must hold h.mu.
+func (h *Hub) hold(client *Client, evt models.Event) bool {
+	if len(client.pending) >= cap(client.Send) {
+		log.Printf("Too many events held during resync, disconnecting a device of user: %s", client.UserID)
+		return !h.removeClient(client)
 	}
+	client.pending = append(client.pending, evt)
+	return true
+}
 
-	// Check if user already connected
-	hub.mu.RLock()
-	if existingClient, exists := hub.Clients[userID]; exists {
-		log.Printf("User %s already connected, closing previous connection", userID)
-		existingClient.Conn.Close()
-		close(existingClient.Send)
-		delete(hub.Clients, userID)
+// removeClient drops a single device and reports whether it was the user's last one.
+// Callers must hold h.mu.
+func (h *Hub) removeClient(client *Client) bool {
+	devices, ok := h.Clients[client.UserID]
+	if !ok || !devices[client] {
+		return false
 	}
-	hub.mu.RUnlock()
 
-	// Create client dengan buffer yang lebih besar
-	client := &Client{
-		Conn:   c,
-		UserID: userID,
-		Send:   make(chan models.Message, 1024), // Increased buffer size
+	delete(devices, client)
+	close(client.Send)
+	h.Connections--
+	log.Printf("User %s disconnected a device (%d left). Total connections: %d", client.UserID, len(devices), h.Connections)
+
+	if len(devices) > 0 {
+		return false
 	}
+	delete(h.Clients, client.UserID)
+	return true
+}
 
-	log.Printf("Registering user %s", userID)
+// dropRevokedClients disconnects devices whose access token or session has been denied,
+// including denials made on other replicas since the last heartbeat
+func (h *Hub) dropRevokedClients() {
+	var offline []string
 
-	// Register client
-	hub.Register <- client
+	h.mu.Lock()
+	for userID, devices := range h.Clients {
+		for client := range devices {
+			if !denylist.Contains(client.TokenID) && !denylist.Contains(client.SessionID.Hex()) {
+				continue
+			}
 
-	// Start goroutines
-	go client.writePump()
-	client.readPump() // readPump akan block sampai connection closed
-}
+			log.Printf("Token revoked, disconnecting a device of user: %s", userID)
+			if h.removeClient(client) {
+				offline = append(offline, userID)
+			}
+		}
+	}
+	h.mu.Unlock()
 
-func WebSocketChatWithAuth(c *websocket.Conn, userID string) {
-	// Check if user already connected
-	hub.mu.RLock()
-	if existingClient, exists := hub.Clients[userID]; exists {
-		log.Printf("User %s already connected, closing previous connection", userID)
-		existingClient.Conn.Close()
-		close(existingClient.Send)
-		delete(hub.Clients, userID)
+	for _, userID := range offline {
+		go setUserOnline(userID, false)
 	}
-	hub.mu.RUnlock()
+}
 
+func WebSocketChatWithAuth(c *websocket.Conn, userID string, sessionID primitive.ObjectID, tokenID string) {
 	// Create client
 	client := &Client{
-		Conn:   c,
-		UserID: userID,
-		Send:   make(chan models.Message, 1024),
+		Conn:      c,
+		UserID:    userID,
+		SessionID: sessionID,
+		TokenID:   tokenID,
+		Send:      make(chan models.Event, 1024),
 	}
 
 	log.Printf("Registering user %s", userID)
@@ -231,7 +329,7 @@ func (c *Client) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.Send:
+		case evt, ok := <-c.Send:
 			c.Conn.SetWriteDeadline(time.Now().Add(15 * time.Second))
 			if !ok {
 				// Channel closed
@@ -239,12 +337,12 @@ func (c *Client) writePump() {
 				return
 			}
 
-			if err := c.Conn.WriteJSON(message); err != nil {
+			if err := c.Conn.WriteJSON(evt); err != nil {
 				log.Printf("Write error for user %s: %v", c.UserID, err)
 				return
 			}
 
-			log.Printf("Message written to websocket for user %s", c.UserID)
+			log.Printf("Event %s written to websocket for user %s", evt.Type, c.UserID)
 
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
@@ -260,6 +358,7 @@ func (c *Client) writePump() {
 func (c *Client) readPump() {
 	defer func() {
 		log.Printf("Read pump stopping for user %s", c.UserID)
+		typing.clearClient(c)
 		hub.Unregister <- c
 		c.Conn.Close()
 	}()
@@ -273,8 +372,8 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		var msgReq models.SendMessageRequest
-		if err := c.Conn.ReadJSON(&msgReq); err != nil {
+		_, raw, err := c.Conn.ReadMessage()
+		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket read error for user %s: %v", c.UserID, err)
 			} else {
@@ -283,100 +382,83 @@ func (c *Client) readPump() {
 			break
 		}
 
-		log.Printf("Message received from user %s: %s", c.UserID, msgReq.Content)
-
-		// Validate message
-		if validationErrors := msgReq.Validate(); len(validationErrors) > 0 {
-			log.Printf("Message validation failed for user %s: %v", c.UserID, validationErrors)
-			continue
-		}
-
-		// Prevent self-messaging
-		if msgReq.ReceiverID == c.UserID {
-			log.Printf("User %s attempted to send message to themselves", c.UserID)
-			continue
-		}
-
-		// Create message
-		message := models.Message{
-			ID:         primitive.NewObjectID(),
-			SenderID:   c.UserID,
-			ReceiverID: msgReq.ReceiverID,
-			Content:    msgReq.Content,
-			Type:       msgReq.Type,
-			Read:       false,
-			CreatedAt:  time.Now(),
-		}
-
-		// Save to database dengan timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		_, err := config.DB.Collection("messages").InsertOne(ctx, message)
-		if err != nil {
-			log.Printf("Failed to save message from user %s: %v", c.UserID, err)
-			continue
-		}
-
-		log.Printf("Message saved to database: %s -> %s", c.UserID, msgReq.ReceiverID)
-
-		// Update user's last seen
-		go func(userID string) {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-
-			_, err := config.DB.Collection("users").UpdateOne(ctx,
-				bson.M{"_id": userID},
-				bson.M{"$set": bson.M{"last_seen": time.Now()}},
-			)
-			if err != nil {
-				log.Printf("Failed to update last_seen for user %s: %v", userID, err)
-			}
-		}(c.UserID)
-
-		// Broadcast message
-		select {
-		case hub.Broadcast <- message:
-			log.Printf("Message broadcast to hub: %s -> %s", message.SenderID, message.ReceiverID)
-		case <-time.After(5 * time.Second):
-			log.Printf("Broadcast channel full, message dropped: %s -> %s", message.SenderID, message.ReceiverID)
-		}
+		c.dispatch(raw)
 	}
 }
 
+// GetMessages pages through a chat with cursors on (created_at, _id). Without a cursor it
+// returns the newest messages; "before" walks back in history and "after" catches up.
 func GetMessages(c *fiber.Ctx) error {
 	currentUserID := c.Locals("user_id").(string)
 	otherUserID := c.Query("user_id")
-	page := c.QueryInt("page", 1)
+	conversationID := c.Query("conversation_id")
+	before := c.Query("before")
+	after := c.Query("after")
 	limit := c.QueryInt("limit", 50)
 
-	if otherUserID == "" {
+	if otherUserID == "" && conversationID == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "user_id parameter is required",
+			"error": "user_id or conversation_id parameter is required",
 		})
 	}
 
+	if before != "" && after != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Only one of before or after may be set",
+		})
+	}
+
+	if limit < 1 {
+		limit = 50
+	}
 	if limit > 100 {
 		limit = 100
 	}
 
-	skip := (page - 1) * limit
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// Find messages between users
-	filter := bson.M{
-		"$or": []bson.M{
-			{"sender_id": currentUserID, "receiver_id": otherUserID},
-			{"sender_id": otherUserID, "receiver_id": currentUserID},
-		},
+	var filter bson.M
+	if conversationID != "" {
+		// Group history is only visible to current members
+		group, err := loadGroupForMember(ctx, conversationID, currentUserID)
+		if err != nil {
+			return groupLookupError(c, err)
+		}
+		filter = bson.M{"conversation_id": group.ID}
+	} else {
+		// Find messages between users
+		filter = bson.M{
+			"$or": []bson.M{
+				{"sender_id": currentUserID, "receiver_id": otherUserID},
+				{"sender_id": otherUserID, "receiver_id": currentUserID},
+			},
+		}
 	}
 
-	opts := options.Find().
-		SetSort(bson.M{"created_at": -1}).
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit))
+	// Skip messages the user deleted for themselves, tombstones stay in place
+	filter["hidden_for"] = bson.M{"$ne": currentUserID}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Newer pages are read oldest first, everything else newest first
+	forward := after != ""
+	direction := -1
+	if forward {
+		direction = 1
+	}
+
+	if position := before + after; position != "" {
+		page, err := cursorFilter(position, !forward)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid cursor",
+			})
+		}
+		filter = bson.M{"$and": []bson.M{filter, page}}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(limit + 1))
 
 	cursor, err := config.DB.Collection("messages").Find(ctx, filter, opts)
 	if err != nil {
@@ -395,45 +477,53 @@ func GetMessages(c *fiber.Ctx) error {
 		})
 	}
 
-	// Reverse to get chronological order
-	for i := len(messages)/2 - 1; i >= 0; i-- {
-		opp := len(messages) - 1 - i
-		messages[i], messages[opp] = messages[opp], messages[i]
+	// The extra message only tells whether another page exists
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
 	}
 
-	// Mark messages as read dengan goroutine
-	go func(currentUserID, otherUserID string) {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	attachReplyPreviews(ctx, messages)
 
-		result, err := config.DB.Collection("messages").UpdateMany(ctx,
-			bson.M{
-				"sender_id":   otherUserID,
-				"receiver_id": currentUserID,
-				"read":        false,
-			},
-			bson.M{"$set": bson.M{"read": true}},
-		)
+	// Reverse to get chronological order
+	if !forward {
+		for i := len(messages)/2 - 1; i >= 0; i-- {
+			opp := len(messages) - 1 - i
+			messages[i], messages[opp] = messages[opp], messages[i]
+		}
+	}
 
-		if err != nil {
-			log.Printf("Failed to mark messages as read: %v", err)
-		} else {
-			log.Printf("Marked %d messages as read", result.ModifiedCount)
+	// The next page continues from the far end: the oldest message going back, the newest going forward
+	var nextCursor string
+	if len(messages) > 0 {
+		edge := messages[0]
+		if forward {
+			edge = messages[len(messages)-1]
 		}
-	}(currentUserID, otherUserID)
+		nextCursor = encodeCursor(edge.CreatedAt, edge.ID)
+	}
 
 	return c.JSON(fiber.Map{
 		"messages": messages,
 		"pagination": fiber.Map{
-			"page":  page,
-			"limit": limit,
-			"total": len(messages),
+			"limit":       limit,
+			"next_cursor": nextCursor,
+			"has_more":    hasMore,
 		},
 	})
 }
 
 func GetConversations(c *fiber.Ctx) error {
 	currentUserID := c.Locals("user_id").(string)
+	limit := c.QueryInt("limit", 20)
+	unreadOnly := c.Query("unread") == "true"
+	archived := c.Query("archived") == "true"
+	requests := c.Query("requests") == "true"
+	now := time.Now()
+
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
 
 	// Aggregation pipeline to get latest message for each conversation
 	pipeline := []bson.M{
@@ -443,10 +533,12 @@ func GetConversations(c *fiber.Ctx) error {
 					{"sender_id": currentUserID},
 					{"receiver_id": currentUserID},
 				},
+				"conversation_id": bson.M{"$exists": false}, // Groups are listed via /chat/groups
+				"hidden_for":      bson.M{"$ne": currentUserID},
 			},
 		},
 		{
-			"$sort": bson.M{"created_at": -1},
+			"$sort": bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
 		},
 		{
 			"$group": bson.M{
@@ -458,6 +550,9 @@ func GetConversations(c *fiber.Ctx) error {
 					},
 				},
 				"last_message": bson.M{"$first": "$$ROOT"},
+				"sent_by_me": bson.M{
+					"$max": bson.M{"$eq": []interface{}{"$sender_id", currentUserID}},
+				},
 				"unread_count": bson.M{
 					"$sum": bson.M{
 						"$cond": []interface{}{
@@ -465,6 +560,7 @@ func GetConversations(c *fiber.Ctx) error {
 								"$and": []bson.M{
 									{"$eq": []interface{}{"$receiver_id", currentUserID}},
 									{"$eq": []interface{}{"$read", false}},
+									{"$eq": []interface{}{bson.M{"$type": "$deleted_at"}, "missing"}},
 								},
 							},
 							1,
@@ -475,10 +571,123 @@ func GetConversations(c *fiber.Ctx) error {
 			},
 		},
 		{
-			"$sort": bson.M{"last_message.created_at": -1},
+			// Own settings for each chat, a chat without settings is not pinned, muted or archived
+			"$lookup": bson.M{
+				"from": "conversation_settings",
+				"let":  bson.M{"peer_id": "$_id"},
+				"pipeline": []bson.M{
+					{"$match": bson.M{
+						"user_id": currentUserID,
+						"$expr":   bson.M{"$eq": []interface{}{"$peer_id", "$$peer_id"}},
+					}},
+				},
+				"as": "settings",
+			},
 		},
+		{
+			// Accepted contact with the other user, the pair key is built like models.ContactPair
+			"$lookup": bson.M{
+				"from": "contacts",
+				"let": bson.M{"pair": bson.M{"$cond": []interface{}{
+					bson.M{"$lt": []interface{}{currentUserID, "$_id"}},
+					bson.M{"$concat": []interface{}{currentUserID, ":", "$_id"}},
+					bson.M{"$concat": []interface{}{"$_id", ":", currentUserID}},
+				}}},
+				"pipeline": []bson.M{
+					{"$match": bson.M{
+						"status": models.ContactStatusAccepted,
+						"$expr":  bson.M{"$eq": []interface{}{"$pair", "$$pair"}},
+					}},
+				},
+				"as": "contact",
+			},
+		},
+		{
+			"$addFields": bson.M{
+				"settings": bson.M{"$arrayElemAt": []interface{}{"$settings", 0}},
+				"contact":  bson.M{"$gt": []interface{}{bson.M{"$size": "$contact"}, 0}},
+			},
+		},
+		{
+			"$addFields": bson.M{
+				"archived": bson.M{"$eq": []interface{}{"$settings.archived", true}},
+				"pinned":   bson.M{"$eq": []interface{}{"$settings.pinned", true}},
+				// A chat a non-contact started is a message request until it is answered or accepted
+				"request": bson.M{"$not": []interface{}{bson.M{"$or": []interface{}{
+					"$contact",
+					"$sent_by_me",
+					bson.M{"$eq": []interface{}{"$settings.request_accepted", true}},
+				}}}},
+				"muted": bson.M{"$and": []interface{}{
+					bson.M{"$eq": []interface{}{"$settings.muted", true}},
+					bson.M{"$or": []interface{}{
+						bson.M{"$eq": []interface{}{bson.M{"$type": "$settings.muted_until"}, "missing"}},
+						bson.M{"$gt": []interface{}{"$settings.muted_until", now}},
+					}},
+				}},
+			},
+		},
+	}
+
+	// Message requests are a separate inbox, archiving only applies to the main one
+	filter := bson.M{"request": requests}
+	if !requests {
+		filter["archived"] = archived
+	}
+	if unreadOnly {
+		filter["unread_count"] = bson.M{"$gt": 0}
+	}
+	pipeline = append(pipeline, bson.M{"$match": filter})
+
+	// Only a page is joined with users, instead of one FindOne per conversation
+	withUsers := []bson.M{
+		{"$lookup": bson.M{
+			"from": "users",
+			"let":  bson.M{"user_id": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$_id", "$$user_id"}}}},
+				{"$project": bson.M{"username": 1, "avatar": 1, "online": 1, "last_seen": 1}},
+			},
+			"as": "user",
+		}},
+		{"$unwind": bson.M{"path": "$user", "preserveNullAndEmptyArrays": true}},
 	}
 
+	// Pinned chats are not paginated, they all lead the first page of the main list
+	page := []bson.M{}
+	facets := bson.M{}
+	cursorParam := c.Query("cursor")
+
+	if !archived && !requests {
+		page = append(page, bson.M{"$match": bson.M{"pinned": false}})
+		if cursorParam == "" {
+			facets["pinned"] = append([]bson.M{
+				{"$match": bson.M{"pinned": true}},
+				{"$sort": bson.M{"settings.pinned_at": -1}},
+			}, withUsers...)
+		}
+	}
+
+	if cursorParam != "" {
+		older, err := cursorFilterOn(cursorParam, true, "last_message.created_at", "last_message._id")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid cursor",
+			})
+		}
+		page = append(page, bson.M{"$match": older})
+	}
+
+	page = append(page,
+		bson.M{"$sort": bson.D{
+			{Key: "last_message.created_at", Value: -1},
+			{Key: "last_message._id", Value: -1},
+		}},
+		bson.M{"$limit": limit + 1},
+	)
+	facets["page"] = append(page, withUsers...)
+	pipeline = append(pipeline, bson.M{"$facet": facets})
+
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
@@ -491,61 +700,95 @@ func GetConversations(c *fiber.Ctx) error {
 	}
 	defer cursor.Close(ctx)
 
-	var conversations []fiber.Map
-	for cursor.Next(ctx) {
-		var result struct {
-			ID          string         `bson:"_id"`
-			LastMessage models.Message `bson:"last_message"`
-			UnreadCount int            `bson:"unread_count"`
-		}
+	type conversationRow struct {
+		ID          string                       `bson:"_id"`
+		LastMessage models.Message               `bson:"last_message"`
+		UnreadCount int                          `bson:"unread_count"`
+		Settings    *models.ConversationSettings `bson:"settings"`
+		Contact     bool                         `bson:"contact"`
+		Request     bool                         `bson:"request"`
+		Archived    bool                         `bson:"archived"`
+		Pinned      bool                         `bson:"pinned"`
+		Muted       bool                         `bson:"muted"`
+		User        *models.User                 `bson:"user"`
+	}
 
-		if err := cursor.Decode(&result); err != nil {
-			log.Printf("Failed to decode conversation: %v", err)
-			continue
-		}
+	var result struct {
+		Pinned []conversationRow `bson:"pinned"`
+		Page   []conversationRow `bson:"page"`
+	}
+	if cursor.Next(ctx) {
+		err = cursor.Decode(&result)
+	} else {
+		err = cursor.Err()
+	}
+	if err != nil {
+		log.Printf("Failed to decode conversations: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to process conversations",
+		})
+	}
 
-		// Get user info
-		var user models.User
-		userCtx, userCancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer userCancel()
+	hasMore := len(result.Page) > limit
+	if hasMore {
+		result.Page = result.Page[:limit]
+	}
 
-		err := config.DB.Collection("users").FindOne(userCtx,
-			bson.M{"_id": result.ID}).Decode(&user)
-		if err != nil {
-			log.Printf("Failed to find user %s: %v", result.ID, err)
+	var nextCursor string
+	if hasMore {
+		last := result.Page[len(result.Page)-1].LastMessage
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	rows := append(result.Pinned, result.Page...)
+	conversations := make([]fiber.Map, 0, len(rows))
+	for _, row := range rows {
+		// The other user was deleted
+		if row.User == nil {
+			log.Printf("Failed to find user %s", row.ID)
 			continue
 		}
 
+		var mutedUntil *time.Time
+		if row.Muted {
+			mutedUntil = row.Settings.MutedUntil
+		}
+
 		conversations = append(conversations, fiber.Map{
 			"user": fiber.Map{
-				"id":        user.ID,
-				"username":  user.Username,
-				"avatar":    user.Avatar,
-				"online":    user.Online,
-				"last_seen": user.LastSeen,
+				"id":        row.User.ID,
+				"username":  row.User.Username,
+				"avatar":    row.User.Avatar,
+				"online":    row.User.Online,
+				"last_seen": row.User.LastSeen,
 			},
 			"last_message": fiber.Map{
-				"id":         result.LastMessage.ID,
-				"content":    result.LastMessage.Content,
-				"type":       result.LastMessage.Type,
-				"created_at": result.LastMessage.CreatedAt,
-				"sender_id":  result.LastMessage.SenderID,
-				"read":       result.LastMessage.Read,
+				"id":         row.LastMessage.ID,
+				"content":    row.LastMessage.Content,
+				"type":       row.LastMessage.Type,
+				"created_at": row.LastMessage.CreatedAt,
+				"sender_id":  row.LastMessage.SenderID,
+				"read":       row.LastMessage.Read,
+				"status":     row.LastMessage.Status,
+				"deleted_at": row.LastMessage.DeletedAt,
 			},
-			"unread_count": result.UnreadCount,
-		})
-	}
-
-	if err := cursor.Err(); err != nil {
-		log.Printf("Cursor error: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to process conversations",
+			"unread_count": row.UnreadCount,
+			"contact":      row.Contact,
+			"request":      row.Request,
+			"archived":     row.Archived,
+			"pinned":       row.Pinned,
+			"muted":        row.Muted,
+			"muted_until":  mutedUntil,
 		})
 	}
 
 	return c.JSON(fiber.Map{
 		"conversations": conversations,
-		"total":         len(conversations),
+		"pagination": fiber.Map{
+			"limit":       limit,
+			"next_cursor": nextCursor,
+			"has_more":    hasMore,
+		},
 	})
 }
 
@@ -562,16 +805,8 @@ func MarkMessagesRead(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Mark all messages from other user as read
-	result, err := config.DB.Collection("messages").UpdateMany(ctx,
-		bson.M{
-			"sender_id":   otherUserID,
-			"receiver_id": currentUserID,
-			"read":        false,
-		},
-		bson.M{"$set": bson.M{"read": true}},
-	)
-
+	// Mark all messages from other user as read and notify the sender
+	count, err := markDirectRead(ctx, currentUserID, otherUserID)
 	if err != nil {
 		log.Printf("Failed to mark messages as read: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -579,11 +814,11 @@ func MarkMessagesRead(c *fiber.Ctx) error {
 		})
 	}
 
-	log.Printf("Marked %d messages as read from %s to %s", result.ModifiedCount, otherUserID, currentUserID)
+	log.Printf("Marked %d messages as read from %s to %s", count, otherUserID, currentUserID)
 
 	return c.JSON(fiber.Map{
 		"message":          "Messages marked as read",
-		"messages_updated": result.ModifiedCount,
+		"messages_updated": count,
 	})
 }
 
@@ -593,12 +828,51 @@ func GetUnreadCount(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	count, err := config.DB.Collection("messages").CountDocuments(ctx,
-		bson.M{
-			"receiver_id": currentUserID,
-			"read":        false,
-		},
-	)
+	groupIDs, err := userGroupIDs(ctx, currentUserID)
+	if err != nil {
+		log.Printf("Failed to load groups: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get unread count",
+		})
+	}
+
+	// Direct messages carry a read flag, group messages a read receipt per member
+	direct := bson.M{"receiver_id": currentUserID, "read": false}
+	group := bson.M{"sender_id": bson.M{"$ne": currentUserID}, "read_by.user_id": bson.M{"$ne": currentUserID}}
+
+	// Badges usually leave out chats the user muted or archived
+	var excluded []bson.M
+	if c.Query("exclude_muted") == "true" {
+		excluded = append(excluded, mutedFilter(time.Now()))
+	}
+	if c.Query("exclude_archived") == "true" {
+		excluded = append(excluded, bson.M{"archived": true})
+	}
+	if len(excluded) > 0 {
+		peerIDs, excludedGroups, err := conversationsWithSettings(ctx, currentUserID, bson.M{"$or": excluded})
+		if err != nil {
+			log.Printf("Failed to load conversation settings: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to get unread count",
+			})
+		}
+		direct["sender_id"] = bson.M{"$nin": peerIDs}
+		groupIDs = withoutObjectIDs(groupIDs, excludedGroups)
+	}
+
+	or := []bson.M{direct}
+	if len(groupIDs) > 0 {
+		group["conversation_id"] = bson.M{"$in": groupIDs}
+		or = append(or, group)
+	}
+
+	filter := bson.M{
+		"$or":        or,
+		"deleted_at": bson.M{"$exists": false},
+		"hidden_for": bson.M{"$ne": currentUserID},
+	}
+
+	count, err := config.DB.Collection("messages").CountDocuments(ctx, filter)
 
 	if err != nil {
 		log.Printf("Failed to get unread count: %v", err)
@@ -617,12 +891,16 @@ func GetConnectionStatus(c *fiber.Ctx) error {
 	hub.mu.RLock()
 	defer hub.mu.RUnlock()
 
-	connectedUsers := make([]string, 0, len(hub.Clients))
-	for userID := range hub.Clients {
-		connectedUsers = append(connectedUsers, userID)
+	connectedUsers := make([]fiber.Map, 0, len(hub.Clients))
+	for userID, devices := range hub.Clients {
+		connectedUsers = append(connectedUsers, fiber.Map{
+			"user_id": userID,
+			"devices": len(devices),
+		})
 	}
 
 	return c.JSON(fiber.Map{
+		"replica_id":        replicaID,
 		"total_connections": hub.Connections,
 		"connected_users":   connectedUsers,
 		"timestamp":         time.Now(),
@@ -0,0 +1,334 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// loadContact returns the contact between both users in any state, nil when there is none
+func loadContact(ctx context.Context, userID, otherID string) (*models.Contact, error) {
+	var contact models.Contact
+	err := config.DB.Collection("contacts").FindOne(ctx,
+		bson.M{"pair": models.ContactPair(userID, otherID)}).Decode(&contact)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// contactsByUser maps each of otherIDs that has a contact with userID to that contact
+func contactsByUser(ctx context.Context, userID string, otherIDs []string) (map[string]*models.Contact, error) {
+	pairs := make([]string, 0, len(otherIDs))
+	for _, id := range otherIDs {
+		pairs = append(pairs, models.ContactPair(userID, id))
+	}
+
+	cursor, err := config.DB.Collection("contacts").Find(ctx, bson.M{"pair": bson.M{"$in": pairs}})
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts []models.Contact
+	if err := cursor.All(ctx, &contacts); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*models.Contact, len(contacts))
+	for i := range contacts {
+		result[contacts[i].Other(userID)] = &contacts[i]
+	}
+	return result, nil
+}
+
+// contactIDs returns the accepted contacts of userID
+func contactIDs(ctx context.Context, userID string) ([]string, error) {
+	cursor, err := config.DB.Collection("contacts").Find(ctx, bson.M{
+		"status": models.ContactStatusAccepted,
+		"$or": []bson.M{
+			{"requester_id": userID},
+			{"addressee_id": userID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts []models.Contact
+	if err := cursor.All(ctx, &contacts); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(contacts))
+	for _, c := range contacts {
+		ids = append(ids, c.Other(userID))
+	}
+	return ids, nil
+}
+
+// AddContact sends a contact request, or accepts the pending request the other user already sent
+func AddContact(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	otherID := c.Params("user_id")
+
+	if otherID == currentUserID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot add yourself as a contact",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	missing, err := usersExist(ctx, []string{otherID})
+	if err != nil {
+		log.Printf("Failed to check contact user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to add contact",
+		})
+	}
+	if missing != "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	blocked, err := isBlocked(ctx, currentUserID, otherID)
+	if err != nil {
+		log.Printf("Failed to check block for contact: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to add contact",
+		})
+	}
+	if blocked {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You cannot add this user",
+		})
+	}
+
+	// The unique pair index makes concurrent requests from both sides end up in one document
+	result, err := config.DB.Collection("contacts").UpdateOne(ctx,
+		bson.M{"pair": models.ContactPair(currentUserID, otherID)},
+		bson.M{"$setOnInsert": bson.M{
+			"requester_id": currentUserID,
+			"addressee_id": otherID,
+			"status":       models.ContactStatusPending,
+			"created_at":   time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("Failed to add contact %s for %s: %v", otherID, currentUserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to add contact",
+		})
+	}
+
+	contact, err := loadContact(ctx, currentUserID, otherID)
+	if err != nil || contact == nil {
+		log.Printf("Failed to load contact %s for %s: %v", otherID, currentUserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to add contact",
+		})
+	}
+
+	// Both users asked for each other, that is as good as an accept
+	if contact.Status == models.ContactStatusPending && contact.AddresseeID == currentUserID {
+		return acceptContact(ctx, c, currentUserID, otherID)
+	}
+
+	if result != nil && result.UpsertedCount > 0 {
+		broadcastEvent([]string{otherID}, models.EventContactChanged, models.ContactChangedPayload{
+			UserID: currentUserID,
+			Status: "incoming",
+		})
+	}
+
+	message := "Contact request sent"
+	if contact.Status == models.ContactStatusAccepted {
+		message = "Already a contact"
+	}
+
+	return c.JSON(fiber.Map{
+		"message": message,
+		"status":  contact.StatusFor(currentUserID),
+	})
+}
+
+func AcceptContact(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return acceptContact(ctx, c, currentUserID, c.Params("user_id"))
+}
+
+// acceptContact accepts the pending request otherID sent to userID
+func acceptContact(ctx context.Context, c *fiber.Ctx, userID, otherID string) error {
+	result, err := config.DB.Collection("contacts").UpdateOne(ctx,
+		bson.M{
+			"pair":         models.ContactPair(userID, otherID),
+			"addressee_id": userID,
+			"status":       models.ContactStatusPending,
+		},
+		bson.M{"$set": bson.M{
+			"status":      models.ContactStatusAccepted,
+			"accepted_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		log.Printf("Failed to accept contact %s for %s: %v", otherID, userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to accept contact",
+		})
+	}
+
+	if result.ModifiedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No pending contact request from this user",
+		})
+	}
+
+	broadcastEvent([]string{otherID}, models.EventContactChanged, models.ContactChangedPayload{
+		UserID: userID,
+		Status: models.ContactStatusAccepted,
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Contact request accepted",
+		"status":  models.ContactStatusAccepted,
+	})
+}
+
+// RemoveContact removes a contact, cancels an outgoing request or declines an incoming one
+func RemoveContact(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	otherID := c.Params("user_id")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := config.DB.Collection("contacts").DeleteOne(ctx,
+		bson.M{"pair": models.ContactPair(currentUserID, otherID)},
+	)
+	if err != nil {
+		log.Printf("Failed to remove contact %s for %s: %v", otherID, currentUserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to remove contact",
+		})
+	}
+
+	if result.DeletedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Contact not found",
+		})
+	}
+
+	broadcastEvent([]string{otherID}, models.EventContactChanged, models.ContactChangedPayload{
+		UserID: currentUserID,
+		Status: "removed",
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Contact removed",
+	})
+}
+
+// ListContacts lists accepted contacts, or pending requests with ?status=incoming|outgoing
+func ListContacts(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	status := c.Query("status", models.ContactStatusAccepted)
+
+	var filter bson.M
+	switch status {
+	case models.ContactStatusAccepted:
+		filter = bson.M{
+			"status": models.ContactStatusAccepted,
+			"$or": []bson.M{
+				{"requester_id": currentUserID},
+				{"addressee_id": currentUserID},
+			},
+		}
+	case "incoming":
+		filter = bson.M{"status": models.ContactStatusPending, "addressee_id": currentUserID}
+	case "outgoing":
+		filter = bson.M{"status": models.ContactStatusPending, "requester_id": currentUserID}
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "status must be accepted, incoming or outgoing",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := config.DB.Collection("contacts").Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$sort": bson.M{"created_at": -1}},
+		{"$lookup": bson.M{
+			"from": "users",
+			"let": bson.M{"user_id": bson.M{"$cond": []interface{}{
+				bson.M{"$eq": []interface{}{"$requester_id", currentUserID}},
+				"$addressee_id",
+				"$requester_id",
+			}}},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$_id", "$$user_id"}}}},
+				{"$project": bson.M{"username": 1, "bio": 1, "avatar": 1, "online": 1, "last_seen": 1}},
+			},
+			"as": "user",
+		}},
+		{"$unwind": "$user"},
+	})
+	if err != nil {
+		log.Printf("Failed to fetch contacts: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch contacts",
+		})
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		models.Contact `bson:",inline"`
+		User           models.User `bson:"user"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		log.Printf("Failed to decode contacts: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch contacts",
+		})
+	}
+
+	contacts := make([]fiber.Map, 0, len(rows))
+	for _, row := range rows {
+		contacts = append(contacts, fiber.Map{
+			"user": fiber.Map{
+				"id":        row.User.ID,
+				"username":  row.User.Username,
+				"bio":       row.User.Bio,
+				"avatar":    row.User.Avatar,
+				"online":    row.User.Online,
+				"last_seen": row.User.LastSeen,
+			},
+			"status":      row.StatusFor(currentUserID),
+			"created_at":  row.CreatedAt,
+			"accepted_at": row.AcceptedAt,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"contacts": contacts,
+		"total":    len(contacts),
+	})
+}
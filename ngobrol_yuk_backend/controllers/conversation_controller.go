@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UpdateConversationSettings pins, mutes or archives the direct chat with :user_id for the
+// caller only, the other user never sees these settings
+func UpdateConversationSettings(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	peerID := c.Params("user_id")
+
+	if peerID == "" || peerID == currentUserID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user_id",
+		})
+	}
+
+	var input models.UpdateConversationSettingsRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if validationErrors := input.Validate(); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"errors": validationErrors,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	missing, err := usersExist(ctx, []string{peerID})
+	if err != nil {
+		log.Printf("Failed to check conversation user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update conversation settings",
+		})
+	}
+	if missing != "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	return saveConversationSettings(ctx, c, currentUserID, bson.M{"peer_id": peerID}, &input)
+}
+
+// UpdateGroupSettings pins, mutes or archives the group :id for the caller only
+func UpdateGroupSettings(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	var input models.UpdateConversationSettingsRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if validationErrors := input.Validate(); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"errors": validationErrors,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, err := loadGroupForMember(ctx, c.Params("id"), currentUserID)
+	if err != nil {
+		return groupLookupError(c, err)
+	}
+
+	return saveConversationSettings(ctx, c, currentUserID, bson.M{"conversation_id": group.ID}, &input)
+}
+
+// saveConversationSettings applies input to the settings of userID for the chat identified by key,
+// either {"peer_id": ...} for a direct chat or {"conversation_id": ...} for a group
+func saveConversationSettings(ctx context.Context, c *fiber.Ctx, userID string, key bson.M, input *models.UpdateConversationSettingsRequest) error {
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	unset := bson.M{}
+
+	// Archiving a chat unpins it and pinning brings it back from the archive
+	if input.Archived != nil {
+		set["archived"] = *input.Archived
+		if *input.Archived {
+			set["pinned"] = false
+			unset["pinned_at"] = ""
+		}
+	}
+
+	if input.Pinned != nil {
+		set["pinned"] = *input.Pinned
+		if *input.Pinned {
+			// Direct chats and groups share the pin limit
+			pinned, err := config.DB.Collection("conversation_settings").CountDocuments(ctx, bson.M{
+				"user_id": userID,
+				"pinned":  true,
+				"$nor":    []bson.M{key},
+			})
+			if err != nil {
+				log.Printf("Failed to count pinned conversations: %v", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to update conversation settings",
+				})
+			}
+			if pinned >= models.MaxPinnedConversations {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "You can pin at most 5 conversations",
+				})
+			}
+
+			set["pinned_at"] = now
+			set["archived"] = false
+		} else {
+			unset["pinned_at"] = ""
+		}
+	}
+
+	if input.MutedUntil != nil {
+		set["muted"] = true
+		set["muted_until"] = *input.MutedUntil
+	} else if input.Muted != nil {
+		set["muted"] = *input.Muted
+		unset["muted_until"] = ""
+	}
+
+	filter := bson.M{"user_id": userID}
+	for k, v := range key {
+		filter[k] = v
+	}
+
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": filter,
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var settings models.ConversationSettings
+	err := config.DB.Collection("conversation_settings").FindOneAndUpdate(ctx,
+		filter,
+		update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&settings)
+	if err != nil {
+		log.Printf("Failed to update conversation settings for %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update conversation settings",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  "Conversation settings updated",
+		"settings": settings,
+	})
+}
+
+// conversationsWithSettings returns the direct chats and groups of userID whose settings match filter,
+// e.g. the muted ones
+func conversationsWithSettings(ctx context.Context, userID string, filter bson.M) ([]string, []primitive.ObjectID, error) {
+	filter["user_id"] = userID
+
+	cursor, err := config.DB.Collection("conversation_settings").Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"peer_id": 1, "conversation_id": 1}),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var settings []models.ConversationSettings
+	if err := cursor.All(ctx, &settings); err != nil {
+		return nil, nil, err
+	}
+
+	peerIDs := make([]string, 0, len(settings))
+	groupIDs := make([]primitive.ObjectID, 0, len(settings))
+	for _, s := range settings {
+		if s.ConversationID != nil {
+			groupIDs = append(groupIDs, *s.ConversationID)
+		} else {
+			peerIDs = append(peerIDs, s.PeerID)
+		}
+	}
+	return peerIDs, groupIDs, nil
+}
+
+// withoutObjectIDs returns ids minus every ID in remove
+func withoutObjectIDs(ids, remove []primitive.ObjectID) []primitive.ObjectID {
+	if len(remove) == 0 {
+		return ids
+	}
+
+	skip := make(map[primitive.ObjectID]bool, len(remove))
+	for _, id := range remove {
+		skip[id] = true
+	}
+
+	kept := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if !skip[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// groupSettings returns the settings of userID for each of its groups that has any, keyed by group
+func groupSettings(ctx context.Context, userID string) (map[primitive.ObjectID]*models.ConversationSettings, error) {
+	cursor, err := config.DB.Collection("conversation_settings").Find(ctx, bson.M{
+		"user_id":         userID,
+		"conversation_id": bson.M{"$type": "objectId"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var settings []models.ConversationSettings
+	if err := cursor.All(ctx, &settings); err != nil {
+		return nil, err
+	}
+
+	byGroup := make(map[primitive.ObjectID]*models.ConversationSettings, len(settings))
+	for i := range settings {
+		byGroup[*settings[i].ConversationID] = &settings[i]
+	}
+	return byGroup, nil
+}
+
+// mutedFilter matches settings that mute the chat right now
+func mutedFilter(now time.Time) bson.M {
+	return bson.M{
+		"muted": true,
+		"$or": []bson.M{
+			{"muted_until": bson.M{"$exists": false}},
+			{"muted_until": bson.M{"$gt": now}},
+		},
+	}
+}
+
+// acceptChat records that userID accepted the direct chat with peerID, writing to it counts as accepting
+func acceptChat(ctx context.Context, userID, peerID string) error {
+	_, err := config.DB.Collection("conversation_settings").UpdateOne(ctx,
+		bson.M{"user_id": userID, "peer_id": peerID},
+		bson.M{
+			"$set":         bson.M{"request_accepted": true},
+			"$setOnInsert": bson.M{"updated_at": time.Now()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// isMessageRequest reports whether the direct chat with peerID is still a message request for userID:
+// peerID wrote first, is not a contact, and userID neither replied nor accepted the request
+func isMessageRequest(ctx context.Context, userID, peerID string) (bool, error) {
+	contact, err := loadContact(ctx, userID, peerID)
+	if err != nil {
+		return false, err
+	}
+	if contact != nil && contact.Status == models.ContactStatusAccepted {
+		return false, nil
+	}
+
+	accepted, err := config.DB.Collection("conversation_settings").CountDocuments(ctx,
+		bson.M{"user_id": userID, "peer_id": peerID, "request_accepted": true},
+		options.Count().SetLimit(1),
+	)
+	if err != nil || accepted > 0 {
+		return false, err
+	}
+
+	replied, err := config.DB.Collection("messages").CountDocuments(ctx,
+		bson.M{"sender_id": userID, "receiver_id": peerID, "hidden_for": bson.M{"$ne": userID}},
+		options.Count().SetLimit(1),
+	)
+	if err != nil || replied > 0 {
+		return false, err
+	}
+
+	received, err := config.DB.Collection("messages").CountDocuments(ctx,
+		bson.M{"sender_id": peerID, "receiver_id": userID, "hidden_for": bson.M{"$ne": userID}},
+		options.Count().SetLimit(1),
+	)
+	return received > 0, err
+}
+
+// AcceptMessageRequest moves the chat with :user_id to the main conversation list
+func AcceptMessageRequest(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	peerID := c.Params("user_id")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	request, err := isMessageRequest(ctx, currentUserID, peerID)
+	if err != nil {
+		log.Printf("Failed to check message request: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to accept message request",
+		})
+	}
+	if !request {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No message request from this user",
+		})
+	}
+
+	if err := acceptChat(ctx, currentUserID, peerID); err != nil {
+		log.Printf("Failed to accept message request for %s: %v", currentUserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to accept message request",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Message request accepted",
+	})
+}
+
+// DeclineMessageRequest deletes the request for the caller only, the sender is not told.
+// A new message from the sender opens a new request.
+func DeclineMessageRequest(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	peerID := c.Params("user_id")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	request, err := isMessageRequest(ctx, currentUserID, peerID)
+	if err != nil {
+		log.Printf("Failed to check message request: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to decline message request",
+		})
+	}
+	if !request {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No message request from this user",
+		})
+	}
+
+	result, err := config.DB.Collection("messages").UpdateMany(ctx,
+		bson.M{"sender_id": peerID, "receiver_id": currentUserID},
+		bson.M{"$addToSet": bson.M{"hidden_for": currentUserID}},
+	)
+	if err != nil {
+		log.Printf("Failed to decline message request for %s: %v", currentUserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to decline message request",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":          "Message request declined",
+		"messages_removed": result.ModifiedCount,
+	})
+}
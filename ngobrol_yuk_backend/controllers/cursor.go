@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor makes an opaque cursor for a position in a (created_at, _id) ordering
+func encodeCursor(at time.Time, id primitive.ObjectID) string {
+	raw := strconv.FormatInt(at.UnixMilli(), 10) + ":" + id.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, errInvalidCursor
+	}
+
+	millis, hex, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, primitive.NilObjectID, errInvalidCursor
+	}
+
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, errInvalidCursor
+	}
+
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, errInvalidCursor
+	}
+
+	return time.UnixMilli(ms), id, nil
+}
+
+// cursorFilter matches documents strictly before (older) or after (newer) the cursor position
+func cursorFilter(cursor string, before bool) (bson.M, error) {
+	return cursorFilterOn(cursor, before, "created_at", "_id")
+}
+
+// cursorFilterOn is cursorFilter for an ordering on other fields, e.g. inside an aggregation
+func cursorFilterOn(cursor string, before bool, timeField, idField string) (bson.M, error) {
+	at, id, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	op := "$gt"
+	if before {
+		op = "$lt"
+	}
+
+	return bson.M{"$or": []bson.M{
+		{timeField: bson.M{op: at}},
+		{timeField: at, idField: bson.M{op: id}},
+	}}, nil
+}
@@ -0,0 +1,560 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errGroupNotFound  = errors.New("group not found")
+	errNotGroupMember = errors.New("not a member of this group")
+)
+
+// loadGroupForMember fetches a group and makes sure userID belongs to it
+func loadGroupForMember(ctx context.Context, groupID, userID string) (*models.Conversation, error) {
+	objID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil {
+		return nil, errGroupNotFound
+	}
+
+	var group models.Conversation
+	err = config.DB.Collection("conversations").FindOne(ctx,
+		bson.M{"_id": objID, "type": models.ConversationTypeGroup}).Decode(&group)
+	if err == mongo.ErrNoDocuments {
+		return nil, errGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if group.Member(userID) == nil {
+		return nil, errNotGroupMember
+	}
+
+	return &group, nil
+}
+
+// groupLookupError maps loadGroupForMember errors to an HTTP response
+func groupLookupError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errGroupNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Group not found",
+		})
+	case errNotGroupMember:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You are not a member of this group",
+		})
+	default:
+		log.Printf("Failed to load group: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load group",
+		})
+	}
+}
+
+// uniqueUserIDs trims, de-duplicates and drops excluded IDs while keeping order
+func uniqueUserIDs(ids []string, exclude ...string) []string {
+	seen := make(map[string]bool, len(ids)+len(exclude))
+	for _, id := range exclude {
+		seen[id] = true
+	}
+
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result
+}
+
+// usersExist reports the first ID in ids that has no matching user
+func usersExist(ctx context.Context, ids []string) (string, error) {
+	if len(ids) == 0 {
+		return "", nil
+	}
+
+	cursor, err := config.DB.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer cursor.Close(ctx)
+
+	found := make(map[string]bool, len(ids))
+	for cursor.Next(ctx) {
+		var u struct {
+			ID string `bson:"_id"`
+		}
+		if err := cursor.Decode(&u); err == nil {
+			found[u.ID] = true
+		}
+	}
+
+	for _, id := range ids {
+		if !found[id] {
+			return id, nil
+		}
+	}
+	return "", cursor.Err()
+}
+
+func CreateGroup(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	var input models.CreateGroupRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if validationErrors := input.Validate(); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"errors": validationErrors,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	memberIDs := uniqueUserIDs(input.MemberIDs, currentUserID)
+	missing, err := usersExist(ctx, memberIDs)
+	if err != nil {
+		log.Printf("Failed to verify group members: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Database error",
+		})
+	}
+	if missing != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User not found: " + missing,
+		})
+	}
+
+	now := time.Now()
+	members := []models.ConversationMember{
+		{UserID: currentUserID, Role: models.RoleOwner, JoinedAt: now},
+	}
+	for _, id := range memberIDs {
+		members = append(members, models.ConversationMember{
+			UserID:   id,
+			Role:     models.RoleMember,
+			JoinedAt: now,
+		})
+	}
+
+	group := models.Conversation{
+		ID:        primitive.NewObjectID(),
+		Type:      models.ConversationTypeGroup,
+		Name:      input.Name,
+		OwnerID:   currentUserID,
+		Members:   members,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if _, err := config.DB.Collection("conversations").InsertOne(ctx, group); err != nil {
+		log.Printf("Failed to create group: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create group",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Group created successfully",
+		"group":   group,
+	})
+}
+
+// ListGroups lists the caller's groups with its own settings for each. Like direct chats,
+// archived groups are only listed with ?archived=true and pinned groups come first.
+func ListGroups(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	archived := c.Query("archived") == "true"
+	now := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := config.DB.Collection("conversations").Find(ctx,
+		bson.M{
+			"type":            models.ConversationTypeGroup,
+			"members.user_id": currentUserID,
+		},
+		options.Find().SetSort(bson.M{"updated_at": -1}),
+	)
+	if err != nil {
+		log.Printf("Failed to fetch groups: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch groups",
+		})
+	}
+	defer cursor.Close(ctx)
+
+	var rows []models.Conversation
+	if err := cursor.All(ctx, &rows); err != nil {
+		log.Printf("Failed to decode groups: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to decode groups",
+		})
+	}
+
+	settings, err := groupSettings(ctx, currentUserID)
+	if err != nil {
+		log.Printf("Failed to load group settings: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch groups",
+		})
+	}
+
+	type groupRow struct {
+		models.Conversation
+		Archived   bool       `json:"archived"`
+		Pinned     bool       `json:"pinned"`
+		Muted      bool       `json:"muted"`
+		MutedUntil *time.Time `json:"muted_until"`
+		pinnedAt   time.Time
+	}
+
+	groups := []groupRow{}
+	for _, g := range rows {
+		row := groupRow{Conversation: g}
+		if s := settings[g.ID]; s != nil {
+			row.Archived = s.Archived
+			row.Pinned = s.Pinned
+			if s.PinnedAt != nil {
+				row.pinnedAt = *s.PinnedAt
+			}
+			if s.IsMuted(now) {
+				row.Muted = true
+				row.MutedUntil = s.MutedUntil
+			}
+		}
+		if row.Archived == archived {
+			groups = append(groups, row)
+		}
+	}
+
+	// Most recently pinned first, the rest keep their updated_at order
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Pinned != groups[j].Pinned {
+			return groups[i].Pinned
+		}
+		return groups[i].pinnedAt.After(groups[j].pinnedAt)
+	})
+
+	return c.JSON(fiber.Map{
+		"groups": groups,
+		"total":  len(groups),
+	})
+}
+
+func GetGroup(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	group, err := loadGroupForMember(ctx, c.Params("id"), currentUserID)
+	if err != nil {
+		return groupLookupError(c, err)
+	}
+
+	return c.JSON(group)
+}
+
+func RenameGroup(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	var input models.RenameGroupRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if validationErrors := input.Validate(); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"errors": validationErrors,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, err := loadGroupForMember(ctx, c.Params("id"), currentUserID)
+	if err != nil {
+		return groupLookupError(c, err)
+	}
+
+	if !group.Member(currentUserID).CanManage() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the owner or an admin can rename the group",
+		})
+	}
+
+	_, err = config.DB.Collection("conversations").UpdateOne(ctx,
+		bson.M{"_id": group.ID},
+		bson.M{"$set": bson.M{"name": input.Name, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Failed to rename group %s: %v", group.ID.Hex(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to rename group",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Group renamed successfully",
+	})
+}
+
+func AddGroupMembers(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	var input models.AddMembersRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if validationErrors := input.Validate(); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"errors": validationErrors,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, err := loadGroupForMember(ctx, c.Params("id"), currentUserID)
+	if err != nil {
+		return groupLookupError(c, err)
+	}
+
+	if !group.Member(currentUserID).CanManage() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the owner or an admin can add members",
+		})
+	}
+
+	newIDs := uniqueUserIDs(input.UserIDs, group.MemberIDs()...)
+	if len(newIDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "All users are already members",
+		})
+	}
+
+	if len(group.Members)+len(newIDs) > models.MaxGroupMembers {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Group member limit reached (max 256)",
+		})
+	}
+
+	missing, err := usersExist(ctx, newIDs)
+	if err != nil {
+		log.Printf("Failed to verify group members: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Database error",
+		})
+	}
+	if missing != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User not found: " + missing,
+		})
+	}
+
+	now := time.Now()
+	newMembers := make([]models.ConversationMember, 0, len(newIDs))
+	for _, id := range newIDs {
+		newMembers = append(newMembers, models.ConversationMember{
+			UserID:   id,
+			Role:     models.RoleMember,
+			JoinedAt: now,
+		})
+	}
+
+	// Guard against concurrent adds of the same user or past the member limit
+	result, err := config.DB.Collection("conversations").UpdateOne(ctx,
+		bson.M{
+			"_id":             group.ID,
+			"members.user_id": bson.M{"$nin": newIDs},
+			"$expr": bson.M{"$lte": bson.A{
+				bson.M{"$size": "$members"}, models.MaxGroupMembers - len(newIDs),
+			}},
+		},
+		bson.M{
+			"$push": bson.M{"members": bson.M{"$each": newMembers}},
+			"$set":  bson.M{"updated_at": now},
+		},
+	)
+	if err != nil {
+		log.Printf("Failed to add members to group %s: %v", group.ID.Hex(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to add members",
+		})
+	}
+
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Group membership changed or member limit reached, please retry",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Members added successfully",
+		"added":   newIDs,
+	})
+}
+
+func RemoveGroupMember(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	targetUserID := c.Params("user_id")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, err := loadGroupForMember(ctx, c.Params("id"), currentUserID)
+	if err != nil {
+		return groupLookupError(c, err)
+	}
+
+	target := group.Member(targetUserID)
+	if target == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User is not a member of this group",
+		})
+	}
+
+	if target.Role == models.RoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The owner cannot be removed from the group",
+		})
+	}
+
+	// Members may always leave; otherwise admins remove members and only the owner removes admins
+	actor := group.Member(currentUserID)
+	if targetUserID != currentUserID {
+		if !actor.CanManage() || (target.Role == models.RoleAdmin && actor.Role != models.RoleOwner) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You are not allowed to remove this member",
+			})
+		}
+	}
+
+	_, err = config.DB.Collection("conversations").UpdateOne(ctx,
+		bson.M{"_id": group.ID},
+		bson.M{
+			"$pull": bson.M{"members": bson.M{"user_id": targetUserID}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		log.Printf("Failed to remove member from group %s: %v", group.ID.Hex(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to remove member",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Member removed successfully",
+	})
+}
+
+func UpdateGroupMemberRole(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	targetUserID := c.Params("user_id")
+
+	var input models.UpdateMemberRoleRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if validationErrors := input.Validate(); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"errors": validationErrors,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, err := loadGroupForMember(ctx, c.Params("id"), currentUserID)
+	if err != nil {
+		return groupLookupError(c, err)
+	}
+
+	if group.Member(currentUserID).Role != models.RoleOwner {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the owner can change member roles",
+		})
+	}
+
+	target := group.Member(targetUserID)
+	if target == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User is not a member of this group",
+		})
+	}
+
+	if target.Role == models.RoleOwner {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "The owner's role cannot be changed",
+		})
+	}
+
+	_, err = config.DB.Collection("conversations").UpdateOne(ctx,
+		bson.M{"_id": group.ID, "members.user_id": targetUserID},
+		bson.M{"$set": bson.M{"members.$.role": input.Role, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		log.Printf("Failed to update member role in group %s: %v", group.ID.Hex(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update member role",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Member role updated successfully",
+	})
+}
+
+// touchGroup bumps updated_at so the group sorts by latest activity
+func touchGroup(groupID primitive.ObjectID, at time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.DB.Collection("conversations").UpdateOne(ctx,
+		bson.M{"_id": groupID},
+		bson.M{"$max": bson.M{"updated_at": at}},
+	)
+	if err != nil {
+		log.Printf("Failed to update activity of group %s: %v", groupID.Hex(), err)
+	}
+}
@@ -0,0 +1,346 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/imaging"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/Adisonsmn/ngobrolyuk/storage"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultMediaMaxSizeMB = 10
+
+// allowedMediaTypes are checked against the sniffed content, never the type the client declares
+var allowedMediaTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"application/zip": true,
+	"text/plain":      true,
+	"audio/mpeg":      true,
+	"audio/wave":      true,
+	"application/ogg": true,
+	"video/mp4":       true,
+	"video/webm":      true,
+}
+
+var mediaStore storage.Storage
+
+// UseMediaStorage sets where uploaded blobs are kept. It must be called once at startup.
+func UseMediaStorage(s storage.Storage) {
+	mediaStore = s
+	log.Printf("Media stored with %T", s)
+}
+
+// MediaMaxSize is the largest accepted upload in bytes, set with MEDIA_MAX_SIZE_MB
+func MediaMaxSize() int64 {
+	mb, err := strconv.Atoi(config.GetEnvWithDefault("MEDIA_MAX_SIZE_MB", strconv.Itoa(defaultMediaMaxSizeMB)))
+	if err != nil || mb <= 0 {
+		mb = defaultMediaMaxSizeMB
+	}
+	return int64(mb) << 20
+}
+
+// sanitizeFileName keeps only the base name, it ends up in a Content-Disposition header
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[len(runes)-255:])
+	}
+	return name
+}
+
+// loadAttachment checks that mediaID was uploaded by userID and fits the message type
+func loadAttachment(ctx context.Context, mediaID, userID, messageType string) (*models.Attachment, error) {
+	objID, _ := primitive.ObjectIDFromHex(mediaID)
+
+	var media models.Media
+	err := config.DB.Collection("media").FindOne(ctx, bson.M{"_id": objID, "owner_id": userID}).Decode(&media)
+	if err == mongo.ErrNoDocuments {
+		return nil, &wsError{Code: models.ErrCodeValidationFailed, Message: "media_id must reference a file you uploaded"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if messageType == models.MessageTypeImage && !media.IsImage() {
+		return nil, &wsError{Code: models.ErrCodeValidationFailed, Message: "Image messages must reference an image"}
+	}
+
+	return media.Attachment(), nil
+}
+
+// canAccessMedia reports whether userID can see a message carrying the media
+func canAccessMedia(ctx context.Context, userID string, media *models.Media) (bool, error) {
+	if media.OwnerID == userID {
+		return true, nil
+	}
+
+	groupIDs, err := userGroupIDs(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	filter := userMessagesFilter(userID, groupIDs)
+	filter["attachment.id"] = media.ID
+	filter["deleted_at"] = bson.M{"$exists": false}
+
+	count, err := config.DB.Collection("messages").CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+func thumbnailKey(id primitive.ObjectID) string {
+	return id.Hex() + "-thumb"
+}
+
+// deleteMediaBlobs cleans up after a failed upload, missing blobs are expected
+func deleteMediaBlobs(ctx context.Context, media *models.Media) {
+	keys := []string{media.ID.Hex()}
+	if media.Thumbnail != nil {
+		keys = append(keys, thumbnailKey(media.ID))
+	}
+
+	for _, key := range keys {
+		if err := mediaStore.Delete(ctx, key); err != nil && err != storage.ErrNotFound {
+			log.Printf("Failed to clean up media blob %s: %v", key, err)
+		}
+	}
+}
+
+func UploadMedia(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Multipart field \"file\" is required",
+		})
+	}
+
+	maxSize := MediaMaxSize()
+	if fileHeader.Size > maxSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "File too large (max " + strconv.FormatInt(maxSize>>20, 10) + " MB)",
+		})
+	}
+
+	if fileHeader.Size == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File is empty",
+		})
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Printf("Failed to open upload: %v", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+	defer file.Close()
+
+	// Sniff the real type from the first bytes, then stream them back in front of the rest
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+	head = head[:n]
+
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !allowedMediaTypes[contentType] {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "File type " + contentType + " is not allowed",
+		})
+	}
+
+	media := models.Media{
+		ID:          primitive.NewObjectID(),
+		OwnerID:     currentUserID,
+		FileName:    sanitizeFileName(fileHeader.Filename),
+		ContentType: contentType,
+		Size:        fileHeader.Size,
+		CreatedAt:   time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	body := io.MultiReader(bytes.NewReader(head), file)
+
+	// Images are cleaned of metadata and get a thumbnail before anything is stored
+	if media.IsImage() {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to read file",
+			})
+		}
+
+		processed, err := imaging.Process(data, media.ContentType)
+		if err != nil {
+			log.Printf("Failed to process image upload: %v", err)
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": "Image could not be processed",
+			})
+		}
+
+		if err := mediaStore.Save(ctx, thumbnailKey(media.ID), bytes.NewReader(processed.Thumbnail)); err != nil {
+			log.Printf("Failed to store thumbnail: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to store file",
+			})
+		}
+
+		media.Size = int64(len(processed.Data))
+		media.ImageInfo = models.ImageInfo{
+			Width:    processed.Width,
+			Height:   processed.Height,
+			Blurhash: processed.Blurhash,
+			Thumbnail: &models.MediaThumbnail{
+				ContentType: imaging.ThumbnailContentType,
+				Width:       processed.ThumbWidth,
+				Height:      processed.ThumbHeight,
+				Size:        int64(len(processed.Thumbnail)),
+			},
+		}
+		body = bytes.NewReader(processed.Data)
+	}
+
+	if err := mediaStore.Save(ctx, media.ID.Hex(), body); err != nil {
+		log.Printf("Failed to store media: %v", err)
+		deleteMediaBlobs(ctx, &media)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store file",
+		})
+	}
+
+	if _, err := config.DB.Collection("media").InsertOne(ctx, media); err != nil {
+		log.Printf("Failed to save media metadata: %v", err)
+		deleteMediaBlobs(ctx, &media)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store file",
+		})
+	}
+
+	log.Printf("Media %s (%s, %d bytes) uploaded by user %s", media.ID.Hex(), media.ContentType, media.Size, currentUserID)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "File uploaded successfully",
+		"media":   media,
+	})
+}
+
+func GetMedia(c *fiber.Ctx) error {
+	return serveMedia(c, false)
+}
+
+func GetMediaThumbnail(c *fiber.Ctx) error {
+	return serveMedia(c, true)
+}
+
+func serveMedia(c *fiber.Ctx, thumbnail bool) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Media not found",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var media models.Media
+	err = config.DB.Collection("media").FindOne(ctx, bson.M{"_id": objID}).Decode(&media)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Media not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to load media: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load media",
+		})
+	}
+
+	allowed, err := canAccessMedia(ctx, currentUserID, &media)
+	if err != nil {
+		log.Printf("Failed to check media access: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load media",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You do not have access to this media",
+		})
+	}
+
+	key, contentType, size := media.ID.Hex(), media.ContentType, media.Size
+	if thumbnail {
+		if media.Thumbnail == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Media has no thumbnail",
+			})
+		}
+		key, contentType, size = thumbnailKey(media.ID), media.Thumbnail.ContentType, media.Thumbnail.Size
+	}
+
+	// The stream is read after the handler returns, so it must outlive the request context
+	blob, err := mediaStore.Open(context.Background(), key)
+	if err == storage.ErrNotFound {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Media not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to open media %s: %v", media.ID.Hex(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load media",
+		})
+	}
+
+	disposition := "attachment"
+	if media.IsImage() {
+		disposition = "inline"
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": media.FileName}))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+
+	return c.SendStream(blob, int(size))
+}
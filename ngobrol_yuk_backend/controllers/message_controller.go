@@ -0,0 +1,387 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultMessageEditWindow = 15 * time.Minute
+
+var (
+	errMessageNotFound    = errors.New("message not found")
+	errNotMessageSender   = errors.New("not the sender of this message")
+	errEditWindowExpired  = errors.New("edit window expired")
+	errMessageEditClashed = errors.New("message was edited concurrently")
+)
+
+// messageEditWindow is how long after sending a message it can still be edited, set with MESSAGE_EDIT_WINDOW (e.g. "15m")
+func messageEditWindow() time.Duration {
+	raw := config.GetEnvWithDefault("MESSAGE_EDIT_WINDOW", "")
+	if raw == "" {
+		return defaultMessageEditWindow
+	}
+
+	window, err := time.ParseDuration(raw)
+	if err != nil || window <= 0 {
+		log.Printf("Invalid MESSAGE_EDIT_WINDOW %q, using %s", raw, defaultMessageEditWindow)
+		return defaultMessageEditWindow
+	}
+	return window
+}
+
+// loadMessageForParticipant fetches a message userID can see and everyone who should
+// see changes to it, including its sender. Tombstones are returned too, they can still be hidden.
+func loadMessageForParticipant(ctx context.Context, messageID, userID string) (*models.Message, []string, error) {
+	objID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, nil, errMessageNotFound
+	}
+
+	var message models.Message
+	err = config.DB.Collection("messages").FindOne(ctx, bson.M{
+		"_id":        objID,
+		"hidden_for": bson.M{"$ne": userID},
+	}).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, errMessageNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if message.ConversationID == nil {
+		if userID != message.SenderID && userID != message.ReceiverID {
+			return nil, nil, errMessageNotFound
+		}
+		return &message, []string{message.ReceiverID, message.SenderID}, nil
+	}
+
+	group, err := loadGroupForMember(ctx, message.ConversationID.Hex(), userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &message, group.MemberIDs(), nil
+}
+
+// loadLiveMessageForParticipant is loadMessageForParticipant for changes that make no sense on a
+// message deleted for everyone, such as editing or reacting
+func loadLiveMessageForParticipant(ctx context.Context, messageID, userID string) (*models.Message, []string, error) {
+	message, recipients, err := loadMessageForParticipant(ctx, messageID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if message.DeletedAt != nil {
+		return nil, nil, errMessageNotFound
+	}
+	return message, recipients, nil
+}
+
+// attachReplyPreviews fills ReplyPreview of every reply with a single query for the quoted messages
+func attachReplyPreviews(ctx context.Context, messages []models.Message) {
+	var ids []primitive.ObjectID
+	for _, m := range messages {
+		if m.ReplyTo != nil {
+			ids = append(ids, *m.ReplyTo)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	cursor, err := config.DB.Collection("messages").Find(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"sender_id": 1, "content": 1, "type": 1, "deleted_at": 1, "created_at": 1}),
+	)
+	if err != nil {
+		log.Printf("Failed to load quoted messages: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var quoted []models.Message
+	if err := cursor.All(ctx, &quoted); err != nil {
+		log.Printf("Failed to decode quoted messages: %v", err)
+		return
+	}
+
+	previews := make(map[primitive.ObjectID]*models.MessagePreview, len(quoted))
+	for i := range quoted {
+		previews[quoted[i].ID] = quoted[i].Preview()
+	}
+
+	for i := range messages {
+		if messages[i].ReplyTo != nil {
+			messages[i].ReplyPreview = previews[*messages[i].ReplyTo]
+		}
+	}
+}
+
+// messageLookupError maps message errors to an HTTP response, like groupLookupError does for groups
+func messageLookupError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errMessageNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Message not found",
+		})
+	case errNotMessageSender:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the sender can change this message",
+		})
+	case errEditWindowExpired:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Message can no longer be edited",
+		})
+	case errMessageEditClashed:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Message was changed at the same time, please retry",
+		})
+	case errTooManyReactions:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Too many different reactions on this message",
+		})
+	default:
+		return groupLookupError(c, err)
+	}
+}
+
+// messageEventError is the WebSocket counterpart of messageLookupError
+func messageEventError(err error) error {
+	switch err {
+	case errMessageNotFound:
+		return &wsError{Code: models.ErrCodeNotFound, Message: "Message not found"}
+	case errNotMessageSender:
+		return &wsError{Code: models.ErrCodeForbidden, Message: "Only the sender can change this message"}
+	case errEditWindowExpired:
+		return &wsError{Code: models.ErrCodeEditWindowExpired, Message: "Message can no longer be edited"}
+	case errMessageEditClashed:
+		return &wsError{Code: models.ErrCodeConflict, Message: "Message was changed at the same time, please retry"}
+	case errTooManyReactions:
+		return &wsError{Code: models.ErrCodeConflict, Message: "Too many different reactions on this message"}
+	default:
+		return groupEventError(err)
+	}
+}
+
+// editMessage replaces the content of userID's own message and archives the previous content
+func editMessage(ctx context.Context, userID string, req *models.EditMessageRequest) (*models.Message, error) {
+	message, recipients, err := loadLiveMessageForParticipant(ctx, req.MessageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.SenderID != userID {
+		return nil, errNotMessageSender
+	}
+
+	if time.Since(message.CreatedAt) > messageEditWindow() {
+		return nil, errEditWindowExpired
+	}
+
+	if message.Content == req.Content {
+		return message, nil
+	}
+
+	// The previous version was written when the message was sent or last edited
+	writtenAt := message.CreatedAt
+	if message.EditedAt != nil {
+		writtenAt = *message.EditedAt
+	}
+	now := time.Now()
+
+	// Matching on the old content keeps two concurrent edits from losing a revision
+	var updated models.Message
+	err = config.DB.Collection("messages").FindOneAndUpdate(ctx,
+		bson.M{"_id": message.ID, "content": message.Content, "deleted_at": bson.M{"$exists": false}},
+		bson.M{
+			"$set":  bson.M{"content": req.Content, "edited_at": now, "updated_at": now},
+			"$push": bson.M{"revisions": models.MessageRevision{Content: message.Content, CreatedAt: writtenAt}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return nil, errMessageEditClashed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	broadcastEvent(recipients, models.EventMessageEdited, models.MessageEditedPayload{
+		MessageID:      updated.ID,
+		ConversationID: updated.ConversationID,
+		SenderID:       updated.SenderID,
+		ReceiverID:     updated.ReceiverID,
+		Content:        updated.Content,
+		EditedAt:       now,
+	})
+
+	log.Printf("Message %s edited by user %s", updated.ID.Hex(), userID)
+	return &updated, nil
+}
+
+// deleteMessage hides a message for userID only, or replaces it with a tombstone for everyone
+func deleteMessage(ctx context.Context, userID string, req *models.DeleteMessageRequest) error {
+	message, recipients, err := loadMessageForParticipant(ctx, req.MessageID, userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var update bson.M
+
+	// A tombstone can still be hidden for oneself, but not deleted again
+	filter := bson.M{"_id": message.ID}
+
+	if req.Mode == models.DeleteForEveryone {
+		if message.DeletedAt != nil {
+			return errMessageNotFound
+		}
+		if message.SenderID != userID {
+			return errNotMessageSender
+		}
+		filter["deleted_at"] = bson.M{"$exists": false}
+
+		// Earlier revisions, attachment metadata and the quoted message would leak the deleted
+		// content, so they go as well as reactions
+		update = bson.M{
+			"$set": bson.M{"content": "", "deleted_at": now, "updated_at": now},
+			"$unset": bson.M{
+				"revisions":  "",
+				"edited_at":  "",
+				"reactions":  "",
+				"attachment": "",
+				"reply_to":   "",
+			},
+		}
+	} else {
+		// Only the caller's own devices need to know
+		recipients = []string{userID}
+		update = bson.M{"$addToSet": bson.M{"hidden_for": userID}}
+	}
+
+	_, err = config.DB.Collection("messages").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	broadcastEvent(recipients, models.EventMessageDeleted, models.MessageDeletedPayload{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		ReceiverID:     message.ReceiverID,
+		Mode:           req.Mode,
+		DeletedAt:      now,
+	})
+
+	log.Printf("Message %s deleted for %s by user %s", message.ID.Hex(), req.Mode, userID)
+	return nil
+}
+
+func EditMessage(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	var input models.EditMessageRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request format",
+		})
+	}
+	input.MessageID = c.Params("id")
+
+	if validationErrors := input.Validate(); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"errors": validationErrors,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	message, err := editMessage(ctx, currentUserID, &input)
+	if err != nil {
+		return messageLookupError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Message updated successfully",
+		"data":    message,
+	})
+}
+
+func DeleteMessage(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	input := models.DeleteMessageRequest{
+		MessageID: c.Params("id"),
+		Mode:      c.Query("mode"),
+	}
+
+	if validationErrors := input.Validate(); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"errors": validationErrors,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := deleteMessage(ctx, currentUserID, &input); err != nil {
+		return messageLookupError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Message deleted successfully",
+		"mode":    input.Mode,
+	})
+}
+
+func handleEditMessage(c *Client, evt models.Event) error {
+	var req models.EditMessageRequest
+	if err := evt.DecodePayload(&req); err != nil {
+		return &wsError{Code: models.ErrCodeInvalidEvent, Message: "Invalid edit_message payload"}
+	}
+
+	if validationErrors := req.Validate(); len(validationErrors) > 0 {
+		return &wsError{Code: models.ErrCodeValidationFailed, Message: "Validation failed", Errors: validationErrors}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Success is confirmed by the message_edited event every participant receives
+	if _, err := editMessage(ctx, c.UserID, &req); err != nil {
+		return messageEventError(err)
+	}
+	return nil
+}
+
+func handleDeleteMessage(c *Client, evt models.Event) error {
+	var req models.DeleteMessageRequest
+	if err := evt.DecodePayload(&req); err != nil {
+		return &wsError{Code: models.ErrCodeInvalidEvent, Message: "Invalid delete_message payload"}
+	}
+
+	if validationErrors := req.Validate(); len(validationErrors) > 0 {
+		return &wsError{Code: models.ErrCodeValidationFailed, Message: "Validation failed", Errors: validationErrors}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Success is confirmed by the message_deleted event
+	if err := deleteMessage(ctx, c.UserID, &req); err != nil {
+		return messageEventError(err)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// presenceHeartbeatInterval is how often last_active is refreshed for connected users
+	presenceHeartbeatInterval = 30 * time.Second
+	// presenceStaleAfter marks a user offline when no heartbeat was seen for this long,
+	// which covers servers that crashed before running Unregister
+	presenceStaleAfter = 3 * presenceHeartbeatInterval
+)
+
+// isConnected reports whether userID has at least one device on this server
+func (h *Hub) isConnected(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.Clients[userID]) > 0
+}
+
+// setUserOnline records whether this replica holds any device of userID and pushes
+// presence_changed to the user's conversation partners when the overall state flips.
+// Every replica keeps its own entry in presence_replicas, so a user stays online
+// until the last device on the last replica disconnects.
+func setUserOnline(userID string, online bool) {
+	// Register and Unregister run these in goroutines, skip stale transitions
+	if hub.isConnected(userID) != online {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	users := config.DB.Collection("users")
+
+	var update interface{}
+	opts := options.FindOneAndUpdate()
+	if online {
+		update = bson.M{
+			"$addToSet": bson.M{"presence_replicas": replicaID},
+			"$set":      bson.M{"online": true, "last_seen": now, "last_active": now},
+		}
+		opts.SetReturnDocument(options.Before)
+	} else {
+		update = []bson.M{
+			{"$set": bson.M{"presence_replicas": bson.M{"$setDifference": []interface{}{
+				bson.M{"$ifNull": []interface{}{"$presence_replicas", []string{}}},
+				[]string{replicaID},
+			}}}},
+			{"$set": bson.M{
+				"online":    bson.M{"$gt": []interface{}{bson.M{"$size": "$presence_replicas"}, 0}},
+				"last_seen": now,
+			}},
+		}
+		opts.SetReturnDocument(options.After)
+	}
+
+	var user models.User
+	err := users.FindOneAndUpdate(ctx, bson.M{"_id": userID}, update, opts).Decode(&user)
+	if err != nil {
+		log.Printf("Failed to set user %s online=%t: %v", userID, online, err)
+		return
+	}
+
+	// user is the state before a connect and after a disconnect, online means nothing flipped
+	if user.Online {
+		return
+	}
+
+	notifyPresence(ctx, userID, online, now)
+}
+
+func notifyPresence(ctx context.Context, userID string, online bool, lastSeen time.Time) {
+	partners, err := conversationPartners(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load conversation partners of user %s: %v", userID, err)
+		return
+	}
+
+	if len(partners) == 0 {
+		return
+	}
+
+	// Partners may be connected to any replica, the hub forwards through the backplane
+	broadcastEvent(partners, models.EventPresenceChanged, models.PresencePayload{
+		UserID:   userID,
+		Online:   online,
+		LastSeen: lastSeen,
+	})
+}
+
+// conversationPartners returns everyone in userID's main conversation list: accepted contacts,
+// direct chats userID wrote in or accepted, and fellow group members. Strangers whose message
+// request is still pending and users on either side of a block never see userID's presence.
+// Every lookup is served by an index, the messages are never scanned.
+func conversationPartners(ctx context.Context, userID string) ([]string, error) {
+	blocked, err := blockedUserIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts, err := contactIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	chats, _, err := conversationsWithSettings(ctx, userID, bson.M{
+		"peer_id":          bson.M{"$type": "string"},
+		"request_accepted": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := config.DB.Collection("conversations").Distinct(ctx, "members.user_id",
+		bson.M{"type": models.ConversationTypeGroup, "members.user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{userID: true}
+	for _, id := range blocked {
+		seen[id] = true
+	}
+
+	var partners []string
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			partners = append(partners, id)
+		}
+	}
+
+	for _, id := range contacts {
+		add(id)
+	}
+	for _, id := range chats {
+		add(id)
+	}
+	for _, v := range members {
+		if id, ok := v.(string); ok {
+			add(id)
+		}
+	}
+	return partners, nil
+}
+
+// heartbeat refreshes last_active of connected users and sweeps users whose server went away
+func (h *Hub) heartbeat() {
+	ticker := time.NewTicker(presenceHeartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.dropRevokedClients()
+
+		if config.DB == nil {
+			continue
+		}
+
+		h.mu.RLock()
+		userIDs := make([]string, 0, len(h.Clients))
+		for userID := range h.Clients {
+			userIDs = append(userIDs, userID)
+		}
+		h.mu.RUnlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		if len(userIDs) > 0 {
+			_, err := config.DB.Collection("users").UpdateMany(ctx,
+				bson.M{"_id": bson.M{"$in": userIDs}},
+				bson.M{
+					"$set":      bson.M{"online": true, "last_active": time.Now()},
+					"$addToSet": bson.M{"presence_replicas": replicaID},
+				},
+			)
+			if err != nil {
+				log.Printf("Failed to refresh presence heartbeat: %v", err)
+			}
+		}
+
+		sweepStalePresence(ctx)
+		cancel()
+	}
+}
+
+// sweepStalePresence marks users offline whose heartbeat stopped on every replica, e.g. after a crash
+func sweepStalePresence(ctx context.Context) {
+	filter := stalePresenceFilter()
+
+	cursor, err := config.DB.Collection("users").Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"_id": 1, "last_active": 1}))
+	if err != nil {
+		log.Printf("Failed to find stale presence: %v", err)
+		return
+	}
+
+	var stale []models.User
+	if err := cursor.All(ctx, &stale); err != nil {
+		log.Printf("Failed to decode stale presence: %v", err)
+		return
+	}
+
+	for _, user := range stale {
+		// Heartbeat failures must never flip a user connected right here
+		if hub.isConnected(user.ID) {
+			continue
+		}
+
+		// Re-check staleness so a heartbeat from another server in between wins
+		filter := stalePresenceFilter()
+		filter["_id"] = user.ID
+
+		result, err := config.DB.Collection("users").UpdateOne(ctx, filter,
+			bson.M{"$set": bson.M{"online": false, "last_seen": user.LastActive, "presence_replicas": []string{}}},
+		)
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+
+		log.Printf("User %s marked offline after missing heartbeats", user.ID)
+		notifyPresence(ctx, user.ID, false, user.LastActive)
+	}
+}
+
+func stalePresenceFilter() bson.M {
+	return bson.M{
+		"online": true,
+		"$or": []bson.M{
+			{"last_active": bson.M{"$lt": time.Now().Add(-presenceStaleAfter)}},
+			{"last_active": bson.M{"$exists": false}},
+		},
+	}
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxReactionEmojis caps the number of distinct emojis on one message
+const maxReactionEmojis = 20
+
+var errTooManyReactions = errors.New("too many different reactions")
+
+// setReaction adds or removes userID's reaction and returns the message's reactions afterwards
+func setReaction(ctx context.Context, userID string, req *models.ReactionRequest, add bool) ([]models.MessageReaction, error) {
+	message, recipients, err := loadLiveMessageForParticipant(ctx, req.MessageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := config.DB.Collection("messages")
+	changed := false
+
+	if add {
+		// Join an existing emoji entry, or start a new one if nobody used this emoji yet.
+		// The push only matches while the emoji is missing, so a racing first reaction
+		// falls back to joining the entry the other user just created.
+		join := func() (*mongo.UpdateResult, error) {
+			return messages.UpdateOne(ctx,
+				bson.M{"_id": message.ID, "reactions.emoji": req.Emoji},
+				bson.M{"$addToSet": bson.M{"reactions.$.user_ids": userID}},
+			)
+		}
+
+		result, err := join()
+		if err != nil {
+			return nil, err
+		}
+
+		if result.MatchedCount == 0 {
+			result, err = messages.UpdateOne(ctx,
+				bson.M{
+					"_id":             message.ID,
+					"reactions.emoji": bson.M{"$ne": req.Emoji},
+					"reactions." + strconv.Itoa(maxReactionEmojis-1): bson.M{"$exists": false},
+				},
+				bson.M{"$push": bson.M{"reactions": models.MessageReaction{Emoji: req.Emoji, UserIDs: []string{userID}}}},
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if result.MatchedCount == 0 {
+			if result, err = join(); err != nil {
+				return nil, err
+			}
+			if result.MatchedCount == 0 {
+				return nil, errTooManyReactions
+			}
+		}
+		changed = result.ModifiedCount > 0
+	} else {
+		result, err := messages.UpdateOne(ctx,
+			bson.M{"_id": message.ID, "reactions.emoji": req.Emoji},
+			bson.M{"$pull": bson.M{"reactions.$.user_ids": userID}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		changed = result.ModifiedCount > 0
+
+		// Drop emojis nobody reacts with anymore
+		if changed {
+			_, err = messages.UpdateOne(ctx,
+				bson.M{"_id": message.ID},
+				bson.M{"$pull": bson.M{"reactions": bson.M{"user_ids": bson.M{"$size": 0}}}},
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Lets devices that were offline pick the change up on resync
+	if changed {
+		_, err = messages.UpdateOne(ctx,
+			bson.M{"_id": message.ID},
+			bson.M{"$set": bson.M{"updated_at": time.Now()}},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var updated models.Message
+	err = messages.FindOne(ctx, bson.M{"_id": message.ID},
+		options.FindOne().SetProjection(bson.M{"reactions": 1}),
+	).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+
+	reactions := updated.Reactions
+	if reactions == nil {
+		reactions = []models.MessageReaction{}
+	}
+
+	if changed {
+		broadcastEvent(recipients, models.EventReactionChanged, models.ReactionChangedPayload{
+			MessageID:      message.ID,
+			ConversationID: message.ConversationID,
+			UserID:         userID,
+			Emoji:          req.Emoji,
+			Added:          add,
+			Reactions:      reactions,
+		})
+	}
+
+	return reactions, nil
+}
+
+func AddReaction(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	var input models.ReactionRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request format",
+		})
+	}
+	input.MessageID = c.Params("id")
+
+	return updateReaction(c, currentUserID, &input, true)
+}
+
+func RemoveReaction(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	input := models.ReactionRequest{
+		MessageID: c.Params("id"),
+		Emoji:     c.Query("emoji"),
+	}
+
+	return updateReaction(c, currentUserID, &input, false)
+}
+
+func updateReaction(c *fiber.Ctx, userID string, input *models.ReactionRequest, add bool) error {
+	if validationErrors := input.Validate(); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"errors": validationErrors,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reactions, err := setReaction(ctx, userID, input, add)
+	if err != nil {
+		return messageLookupError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"reactions": reactions,
+	})
+}
+
+func handleReaction(add bool) eventHandler {
+	return func(c *Client, evt models.Event) error {
+		var req models.ReactionRequest
+		if err := evt.DecodePayload(&req); err != nil {
+			return &wsError{Code: models.ErrCodeInvalidEvent, Message: "Invalid " + evt.Type + " payload"}
+		}
+
+		if validationErrors := req.Validate(); len(validationErrors) > 0 {
+			return &wsError{Code: models.ErrCodeValidationFailed, Message: "Validation failed", Errors: validationErrors}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Success is confirmed by the reaction_changed event
+		if _, err := setReaction(ctx, c.UserID, &req, add); err != nil {
+			return messageEventError(err)
+		}
+		return nil
+	}
+}
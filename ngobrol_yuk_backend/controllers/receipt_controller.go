@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// pendingReceipt is a message whose state is about to change, grouped later by sender
+type pendingReceipt struct {
+	ID             primitive.ObjectID  `bson:"_id"`
+	SenderID       string              `bson:"sender_id"`
+	ConversationID *primitive.ObjectID `bson:"conversation_id"`
+}
+
+func findPendingReceipts(ctx context.Context, filter bson.M) ([]pendingReceipt, error) {
+	cursor, err := config.DB.Collection("messages").Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"_id": 1, "sender_id": 1, "conversation_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var pending []pendingReceipt
+	if err := cursor.All(ctx, &pending); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func receiptIDs(pending []pendingReceipt) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(pending))
+	for _, p := range pending {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+// notifyReceipts pushes one receipt event per sender, and mirrors read receipts to the reader's other devices
+func notifyReceipts(userID, status string, at time.Time, pending []pendingReceipt) {
+	type receiptGroup struct {
+		senderID       string
+		conversationID string
+	}
+
+	grouped := make(map[receiptGroup][]primitive.ObjectID)
+	for _, p := range pending {
+		key := receiptGroup{senderID: p.SenderID}
+		if p.ConversationID != nil {
+			key.conversationID = p.ConversationID.Hex()
+		}
+		grouped[key] = append(grouped[key], p.ID)
+	}
+
+	for key, ids := range grouped {
+		recipients := []string{key.senderID}
+		if status == models.MessageStatusRead {
+			recipients = append(recipients, userID)
+		}
+
+		broadcastEvent(recipients, models.EventReceipt, models.ReceiptPayload{
+			Status:         status,
+			UserID:         userID,
+			ConversationID: key.conversationID,
+			MessageIDs:     ids,
+			At:             at,
+		})
+	}
+}
+
+// markDelivered records that userID's device received the given messages
+func markDelivered(ctx context.Context, userID string, ids []primitive.ObjectID) (int, error) {
+	now := time.Now()
+
+	// Direct messages: sent -> delivered (legacy messages have no status yet)
+	direct, err := findPendingReceipts(ctx, bson.M{
+		"_id":         bson.M{"$in": ids},
+		"receiver_id": userID,
+		"status":      bson.M{"$nin": []string{models.MessageStatusDelivered, models.MessageStatusRead}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(direct) > 0 {
+		_, err = config.DB.Collection("messages").UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": receiptIDs(direct)}},
+			bson.M{"$set": bson.M{"status": models.MessageStatusDelivered, "delivered_at": now}},
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Group messages: one receipt per member, only for groups the user still belongs to
+	groupIDs, err := userGroupIDs(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var group []pendingReceipt
+	if len(groupIDs) > 0 {
+		group, err = findPendingReceipts(ctx, bson.M{
+			"_id":                  bson.M{"$in": ids},
+			"conversation_id":      bson.M{"$in": groupIDs},
+			"sender_id":            bson.M{"$ne": userID},
+			"delivered_to.user_id": bson.M{"$ne": userID},
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(group) > 0 {
+		_, err = config.DB.Collection("messages").UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": receiptIDs(group)}, "delivered_to.user_id": bson.M{"$ne": userID}},
+			bson.M{"$push": bson.M{"delivered_to": models.MessageReceipt{UserID: userID, At: now}}},
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	pending := append(direct, group...)
+	notifyReceipts(userID, models.MessageStatusDelivered, now, pending)
+	return len(pending), nil
+}
+
+// markDirectRead marks every message from otherUserID to userID as read
+func markDirectRead(ctx context.Context, userID, otherUserID string) (int, error) {
+	now := time.Now()
+
+	pending, err := findPendingReceipts(ctx, bson.M{
+		"sender_id":   otherUserID,
+		"receiver_id": userID,
+		"read":        false,
+	})
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	// Reading implies delivery, the pipeline update keeps an earlier delivered_at
+	_, err = config.DB.Collection("messages").UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": receiptIDs(pending)}},
+		[]bson.M{{"$set": bson.M{
+			"read":         true,
+			"status":       models.MessageStatusRead,
+			"read_at":      now,
+			"delivered_at": bson.M{"$ifNull": []interface{}{"$delivered_at", now}},
+		}}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	notifyReceipts(userID, models.MessageStatusRead, now, pending)
+	return len(pending), nil
+}
+
+// markGroupRead marks every message in a group as read by userID
+func markGroupRead(ctx context.Context, userID string, groupID primitive.ObjectID) (int, error) {
+	now := time.Now()
+
+	pending, err := findPendingReceipts(ctx, bson.M{
+		"conversation_id": groupID,
+		"sender_id":       bson.M{"$ne": userID},
+		"read_by.user_id": bson.M{"$ne": userID},
+	})
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	ids := receiptIDs(pending)
+	receipt := models.MessageReceipt{UserID: userID, At: now}
+
+	_, err = config.DB.Collection("messages").UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "read_by.user_id": bson.M{"$ne": userID}},
+		bson.M{"$push": bson.M{"read_by": receipt}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	// Reading implies delivery
+	_, err = config.DB.Collection("messages").UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "delivered_to.user_id": bson.M{"$ne": userID}},
+		bson.M{"$push": bson.M{"delivered_to": receipt}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	notifyReceipts(userID, models.MessageStatusRead, now, pending)
+	return len(pending), nil
+}
+
+func handleMessageDelivered(c *Client, evt models.Event) error {
+	var req models.DeliveredRequest
+	if err := evt.DecodePayload(&req); err != nil {
+		return &wsError{Code: models.ErrCodeInvalidEvent, Message: "Invalid message_delivered payload"}
+	}
+
+	if validationErrors := req.Validate(); len(validationErrors) > 0 {
+		return &wsError{Code: models.ErrCodeValidationFailed, Message: "Validation failed", Errors: validationErrors}
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(req.MessageIDs))
+	for _, id := range req.MessageIDs {
+		objID, _ := primitive.ObjectIDFromHex(id)
+		ids = append(ids, objID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count, err := markDelivered(ctx, c.UserID, ids)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Marked %d messages as delivered to %s", count, c.UserID)
+	return nil
+}
+
+func handleMessageRead(c *Client, evt models.Event) error {
+	var req models.ReadRequest
+	if err := evt.DecodePayload(&req); err != nil {
+		return &wsError{Code: models.ErrCodeInvalidEvent, Message: "Invalid message_read payload"}
+	}
+
+	if validationErrors := req.Validate(); len(validationErrors) > 0 {
+		return &wsError{Code: models.ErrCodeValidationFailed, Message: "Validation failed", Errors: validationErrors}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var count int
+	if req.ConversationID != "" {
+		group, err := loadGroupForMember(ctx, req.ConversationID, c.UserID)
+		if err != nil {
+			return groupEventError(err)
+		}
+		if count, err = markGroupRead(ctx, c.UserID, group.ID); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if count, err = markDirectRead(ctx, c.UserID, req.UserID); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Marked %d messages as read by %s", count, c.UserID)
+	return nil
+}
+
+func MarkGroupMessagesRead(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, err := loadGroupForMember(ctx, c.Params("id"), currentUserID)
+	if err != nil {
+		return groupLookupError(c, err)
+	}
+
+	count, err := markGroupRead(ctx, currentUserID, group.ID)
+	if err != nil {
+		log.Printf("Failed to mark group messages as read: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to mark messages as read",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":          "Messages marked as read",
+		"messages_updated": count,
+	})
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// reportEvidence loads the reported messages, they must be sent by reportedID to reporterID or one of their groups
+func reportEvidence(ctx context.Context, reporterID, reportedID string, messageIDs []string) ([]models.Message, bool, error) {
+	ids := make([]primitive.ObjectID, 0, len(messageIDs))
+	seen := make(map[primitive.ObjectID]bool, len(messageIDs))
+	for _, hex := range messageIDs {
+		id, _ := primitive.ObjectIDFromHex(hex)
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	groupIDs, err := userGroupIDs(ctx, reporterID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Sent to the reporter directly or in one of their groups. Messages the reporter deleted
+	// for themselves (hidden_for) stay reportable, hiding abuse is a common first reaction.
+	participant := []bson.M{{"receiver_id": reporterID}}
+	if len(groupIDs) > 0 {
+		participant = append(participant, bson.M{"conversation_id": bson.M{"$in": groupIDs}})
+	}
+
+	cursor, err := config.DB.Collection("messages").Find(ctx, bson.M{
+		"_id":        bson.M{"$in": ids},
+		"sender_id":  reportedID,
+		"deleted_at": bson.M{"$exists": false},
+		"$or":        participant,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, false, err
+	}
+
+	return messages, len(messages) == len(ids), nil
+}
+
+func ReportUser(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	reportedID := c.Params("id")
+
+	if reportedID == currentUserID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot report yourself",
+		})
+	}
+
+	var input models.ReportUserRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if validationErrors := input.Validate(); len(validationErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Validation failed",
+			"errors": validationErrors,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	missing, err := usersExist(ctx, []string{reportedID})
+	if err != nil {
+		log.Printf("Failed to check reported user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to submit report",
+		})
+	}
+	if missing != "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	report := models.Report{
+		ID:         primitive.NewObjectID(),
+		ReporterID: currentUserID,
+		ReportedID: reportedID,
+		Reason:     input.Reason,
+		Details:    input.Details,
+		Status:     models.ReportStatusOpen,
+		CreatedAt:  time.Now(),
+	}
+
+	if len(input.MessageIDs) > 0 {
+		evidence, complete, err := reportEvidence(ctx, currentUserID, reportedID, input.MessageIDs)
+		if err != nil {
+			log.Printf("Failed to load report evidence: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to submit report",
+			})
+		}
+		if !complete {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "message_ids must reference messages this user sent in your conversations",
+			})
+		}
+		report.Evidence = evidence
+	}
+
+	if _, err := config.DB.Collection("reports").InsertOne(ctx, report); err != nil {
+		log.Printf("Failed to save report from %s: %v", currentUserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to submit report",
+		})
+	}
+
+	log.Printf("User %s reported %s for %s", currentUserID, reportedID, report.Reason)
+
+	if input.Block {
+		if err := blockUser(ctx, currentUserID, reportedID); err != nil {
+			log.Printf("Failed to block reported user %s: %v", reportedID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Report submitted but the user could not be blocked",
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":   "Report submitted",
+		"report_id": report.ID,
+		"blocked":   input.Block,
+	})
+}
@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	searchSnippetLength = 160 // Characters around the first match
+	searchSnippetLead   = 40  // Characters kept before the first match
+)
+
+// searchTerms extracts the words and quoted phrases of a $text query, negated terms are skipped
+func searchTerms(q string) []string {
+	var terms []string
+
+	for q != "" {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			break
+		}
+
+		if q[0] == '"' {
+			phrase, rest, _ := strings.Cut(q[1:], `"`)
+			if phrase = strings.TrimSpace(phrase); phrase != "" {
+				terms = append(terms, phrase)
+			}
+			q = rest
+			continue
+		}
+
+		end := strings.IndexFunc(q, unicode.IsSpace)
+		if end < 0 {
+			end = len(q)
+		}
+		if word := q[:end]; !strings.HasPrefix(word, "-") {
+			terms = append(terms, word)
+		}
+		q = q[end:]
+	}
+
+	return terms
+}
+
+// highlightSnippet cuts content around the first whole-word match of terms and returns the
+// [start, end) rune offsets of every match inside the snippet
+func highlightSnippet(content string, terms []string) (string, [][2]int) {
+	text := []rune(content)
+
+	// Lower-case rune by rune so offsets stay aligned with text
+	lower := make([]rune, len(text))
+	for i, r := range text {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	isWordRune := func(i int) bool {
+		return i >= 0 && i < len(lower) && (unicode.IsLetter(lower[i]) || unicode.IsDigit(lower[i]))
+	}
+
+	var matches [][2]int
+	for _, term := range terms {
+		needle := []rune(strings.ToLower(term))
+		if len(needle) == 0 {
+			continue
+		}
+
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if string(lower[i:i+len(needle)]) != string(needle) {
+				continue
+			}
+			if isWordRune(i-1) || isWordRune(i+len(needle)) {
+				continue
+			}
+			matches = append(matches, [2]int{i, i + len(needle)})
+			i += len(needle) - 1
+		}
+	}
+
+	sort.Slice(matches, func(a, b int) bool { return matches[a][0] < matches[b][0] })
+
+	start := 0
+	if len(matches) > 0 {
+		start = max(0, matches[0][0]-searchSnippetLead)
+	}
+	end := min(len(text), start+searchSnippetLength)
+
+	var snippet strings.Builder
+	offset := -start
+	if start > 0 {
+		snippet.WriteString("…")
+		offset++
+	}
+	snippet.WriteString(string(text[start:end]))
+	if end < len(text) {
+		snippet.WriteString("…")
+	}
+
+	highlights := make([][2]int, 0, len(matches))
+	lastEnd := 0
+	for _, m := range matches {
+		if m[0] < start || m[1] > end || m[0]+offset < lastEnd {
+			continue
+		}
+		highlights = append(highlights, [2]int{m[0] + offset, m[1] + offset})
+		lastEnd = m[1] + offset
+	}
+
+	return snippet.String(), highlights
+}
+
+// searchConversations loads the context shown next to each result: the other user or the group
+func searchConversations(ctx context.Context, currentUserID string, messages []models.Message) map[string]fiber.Map {
+	userIDs := make(map[string]bool)
+	groupIDs := make(map[primitive.ObjectID]bool)
+
+	for _, m := range messages {
+		if m.ConversationID != nil {
+			groupIDs[*m.ConversationID] = true
+		} else if m.SenderID == currentUserID {
+			userIDs[m.ReceiverID] = true
+		} else {
+			userIDs[m.SenderID] = true
+		}
+	}
+
+	contexts := make(map[string]fiber.Map)
+
+	if len(userIDs) > 0 {
+		ids := make([]string, 0, len(userIDs))
+		for id := range userIDs {
+			ids = append(ids, id)
+		}
+
+		cursor, err := config.DB.Collection("users").Find(ctx,
+			bson.M{"_id": bson.M{"$in": ids}},
+			options.Find().SetProjection(bson.M{"username": 1, "avatar": 1}),
+		)
+		if err != nil {
+			log.Printf("Failed to load search result users: %v", err)
+		} else {
+			var users []models.User
+			if err := cursor.All(ctx, &users); err != nil {
+				log.Printf("Failed to decode search result users: %v", err)
+			}
+			for _, u := range users {
+				contexts["user:"+u.ID] = fiber.Map{
+					"type": "direct",
+					"user": fiber.Map{
+						"id":       u.ID,
+						"username": u.Username,
+						"avatar":   u.Avatar,
+					},
+				}
+			}
+		}
+	}
+
+	if len(groupIDs) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(groupIDs))
+		for id := range groupIDs {
+			ids = append(ids, id)
+		}
+
+		cursor, err := config.DB.Collection("conversations").Find(ctx,
+			bson.M{"_id": bson.M{"$in": ids}},
+			options.Find().SetProjection(bson.M{"name": 1}),
+		)
+		if err != nil {
+			log.Printf("Failed to load search result groups: %v", err)
+		} else {
+			var groups []models.Conversation
+			if err := cursor.All(ctx, &groups); err != nil {
+				log.Printf("Failed to decode search result groups: %v", err)
+			}
+			for _, g := range groups {
+				contexts["group:"+g.ID.Hex()] = fiber.Map{
+					"type": "group",
+					"id":   g.ID,
+					"name": g.Name,
+				}
+			}
+		}
+	}
+
+	return contexts
+}
+
+func SearchMessages(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	q := strings.TrimSpace(c.Query("q"))
+	limit := c.QueryInt("limit", 20)
+
+	if utf8.RuneCountInString(q) < 2 || len(q) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "q must be between 2 and 100 characters",
+		})
+	}
+
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "q must contain at least one term that is not excluded",
+		})
+	}
+
+	if limit < 1 || limit > 50 {
+		limit = 20
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	groupIDs, err := userGroupIDs(ctx, currentUserID)
+	if err != nil {
+		log.Printf("Failed to load groups for search: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search messages",
+		})
+	}
+
+	// Only conversations the caller is part of, without messages deleted for them or for everyone
+	filters := []bson.M{
+		{"$text": bson.M{"$search": q}},
+		userMessagesFilter(currentUserID, groupIDs),
+		{"deleted_at": bson.M{"$exists": false}},
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		older, err := cursorFilter(cursor, true)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid cursor",
+			})
+		}
+		filters = append(filters, older)
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+
+	cursor, err := config.DB.Collection("messages").Find(ctx, bson.M{"$and": filters}, opts)
+	if err != nil {
+		log.Printf("Failed to search messages: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search messages",
+		})
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		log.Printf("Failed to decode search results: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search messages",
+		})
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	contexts := searchConversations(ctx, currentUserID, messages)
+
+	results := make([]fiber.Map, 0, len(messages))
+	for _, m := range messages {
+		key := "group:"
+		if m.ConversationID != nil {
+			key += m.ConversationID.Hex()
+		} else if m.SenderID == currentUserID {
+			key = "user:" + m.ReceiverID
+		} else {
+			key = "user:" + m.SenderID
+		}
+
+		snippet, highlights := highlightSnippet(m.Content, terms)
+		results = append(results, fiber.Map{
+			"message":      m,
+			"snippet":      snippet,
+			"highlights":   highlights,
+			"conversation": contexts[key],
+		})
+	}
+
+	var nextCursor string
+	if hasMore {
+		last := messages[len(messages)-1]
+		nextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	return c.JSON(fiber.Map{
+		"results":     results,
+		"next_cursor": nextCursor,
+		"has_more":    hasMore,
+	})
+}
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/backplane"
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/denylist"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	// maxPreviousHashes bounds how far back a replayed refresh token is still recognised
+	maxPreviousHashes = 50
+
+	maxUserAgentLength = 512
+
+	refreshCookieName = "refresh_token"
+	refreshCookiePath = "/api/v1/auth"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// tokenTTL reads a Go duration such as "15m" from the environment
+func tokenTTL(key string, fallback time.Duration) time.Duration {
+	raw := config.GetEnvWithDefault(key, "")
+	if raw == "" {
+		return fallback
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, raw, fallback)
+		return fallback
+	}
+	return ttl
+}
+
+// accessTokenTTL is the lifetime of a JWT, set with ACCESS_TOKEN_TTL
+func accessTokenTTL() time.Duration {
+	return tokenTTL("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// refreshTokenTTL is how long a session survives without being refreshed, set with REFRESH_TOKEN_TTL
+func refreshTokenTTL() time.Duration {
+	return tokenTTL("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// newRefreshToken returns an opaque random token, only its hash is ever stored
+func newRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession starts a new refresh token family for userID on the device described by userAgent and ip
+func createSession(ctx context.Context, userID, userAgent, ip string) (*models.Session, string, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if runes := []rune(userAgent); len(runes) > maxUserAgentLength {
+		userAgent = string(runes[:maxUserAgentLength])
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		TokenHash:  hashRefreshToken(token),
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL()),
+	}
+
+	if _, err := config.DB.Collection("sessions").InsertOne(ctx, session); err != nil {
+		return nil, "", err
+	}
+	return &session, token, nil
+}
+
+// rotateSession swaps a refresh token for a new one. Presenting a token that was already
+// rotated means it leaked or was stolen, so the whole family is revoked.
+func rotateSession(ctx context.Context, token string) (*models.Session, string, error) {
+	newToken, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := hashRefreshToken(token)
+	now := time.Now()
+	sessions := config.DB.Collection("sessions")
+
+	var session models.Session
+	err = sessions.FindOneAndUpdate(ctx,
+		bson.M{
+			"token_hash": hash,
+			"revoked_at": bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{
+			"$set": bson.M{
+				"token_hash":   hashRefreshToken(newToken),
+				"last_used_at": now,
+				"expires_at":   now.Add(refreshTokenTTL()),
+			},
+			"$push": bson.M{"previous_hashes": bson.M{"$each": []string{hash}, "$slice": -maxPreviousHashes}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&session)
+	if err == nil {
+		// Denied by a revocation that failed before marking the session revoked
+		if denylist.Contains(session.ID.Hex()) {
+			if _, err := revokeSession(ctx, session.ID, session.UserID, models.SessionRevokedByUser); err != nil {
+				return nil, "", err
+			}
+			return nil, "", errInvalidRefreshToken
+		}
+		return &session, newToken, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, "", err
+	}
+
+	err = sessions.FindOne(ctx,
+		bson.M{"previous_hashes": hash, "revoked_at": bson.M{"$exists": false}},
+	).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, "", errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	log.Printf("Refresh token reuse detected, revoking session %s of user %s", session.ID.Hex(), session.UserID)
+	if _, err := revokeSession(ctx, session.ID, session.UserID, models.SessionRevokedReuse); err != nil {
+		return nil, "", err
+	}
+	return nil, "", errRefreshTokenReused
+}
+
+// revokeSession ends a session of userID. Its refresh token and access tokens stop working
+// immediately and the WebSockets opened with it are closed.
+// The access tokens are denied before the session is marked revoked, so a failed attempt can be retried.
+func revokeSession(ctx context.Context, sessionID primitive.ObjectID, userID, reason string) (bool, error) {
+	sessions := config.DB.Collection("sessions")
+	active := bson.M{"_id": sessionID, "user_id": userID, "revoked_at": bson.M{"$exists": false}}
+
+	found, err := sessions.CountDocuments(ctx, active, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	if found == 0 {
+		return false, nil
+	}
+
+	if err := denySession(ctx, userID, sessionID, reason); err != nil {
+		return false, err
+	}
+
+	result, err := sessions.UpdateOne(ctx, active,
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoke_reason": reason}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 0 {
+		// Revoked concurrently, that request closes the sockets
+		return false, nil
+	}
+
+	closeSessionSockets(ctx, userID, sessionID, reason)
+	return true, nil
+}
+
+// denySession rejects the access tokens already issued for a revoked session. They expire
+// within accessTokenTTL, so the denylist entry does not need to live longer than that.
+func denySession(ctx context.Context, userID string, sessionID primitive.ObjectID, reason string) error {
+	return denylist.Add(ctx, denylist.Entry{
+		ID:        sessionID.Hex(),
+		Kind:      denylist.KindSession,
+		UserID:    userID,
+		Reason:    reason,
+		ExpiresAt: time.Now().Add(accessTokenTTL()),
+	})
+}
+
+// closeSessionSockets tells the devices of the session why they are disconnected and drops them.
+// The event is published to the other replicas directly rather than through the hub's outbound
+// queue, which gives up when it is full; they would otherwise only drop the devices on the heartbeat.
+func closeSessionSockets(ctx context.Context, userID string, sessionID primitive.ObjectID, reason string) {
+	evt, err := models.NewEvent(models.EventSessionRevoked, "", models.SessionRevokedPayload{
+		SessionID: sessionID,
+		Reason:    reason,
+	})
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", models.EventSessionRevoked, err)
+		return
+	}
+
+	hub.mu.RLock()
+	bp := hub.backplane
+	hub.mu.RUnlock()
+
+	if bp != nil {
+		err := bp.Publish(ctx, backplane.Message{Origin: replicaID, UserIDs: []string{userID}, Event: evt})
+		if err != nil {
+			log.Printf("Failed to publish revocation of session %s: %v", sessionID.Hex(), err)
+		}
+	}
+
+	select {
+	case hub.Broadcast <- Delivery{UserIDs: []string{userID}, Event: evt, fromPeer: true}:
+	case <-time.After(5 * time.Second):
+		log.Printf("Broadcast channel full, devices of session %s are dropped on the next heartbeat", sessionID.Hex())
+	}
+}
+
+// issueTokens signs an access token for session and sets both auth cookies.
+// The tokens are returned as well for clients that use the Authorization header.
+func issueTokens(c *fiber.Ctx, session *models.Session, refreshToken string) (fiber.Map, error) {
+	accessExpiresAt := time.Now().Add(accessTokenTTL())
+
+	accessToken, err := generateJWT(session.UserID, session.ID.Hex(), accessExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	setJWTCookie(c, accessToken, accessExpiresAt)
+	setRefreshCookie(c, refreshToken, session.ExpiresAt)
+
+	return fiber.Map{
+		"access_token":             accessToken,
+		"access_token_expires_at":  accessExpiresAt,
+		"refresh_token":            refreshToken,
+		"refresh_token_expires_at": session.ExpiresAt,
+	}, nil
+}
+
+// startSession logs userID in on a new session, used by Register and Login
+func startSession(c *fiber.Ctx, userID string) (fiber.Map, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, refreshToken, err := createSession(ctx, userID, c.Get(fiber.HeaderUserAgent), c.IP())
+	if err != nil {
+		return nil, err
+	}
+	return issueTokens(c, session, refreshToken)
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListSessions lists the devices the user is logged in on, most recently used first
+func ListSessions(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	currentSessionID := c.Locals("session_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := config.DB.Collection("sessions").Find(ctx,
+		bson.M{
+			"user_id":    currentUserID,
+			"revoked_at": bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": time.Now()},
+		},
+		options.Find().
+			SetSort(bson.D{{Key: "last_used_at", Value: -1}}).
+			SetProjection(bson.M{"token_hash": 0, "previous_hashes": 0}),
+	)
+	if err != nil {
+		log.Printf("Failed to fetch sessions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch sessions",
+		})
+	}
+	defer cursor.Close(ctx)
+
+	var rows []models.Session
+	if err := cursor.All(ctx, &rows); err != nil {
+		log.Printf("Failed to decode sessions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch sessions",
+		})
+	}
+
+	sessions := make([]fiber.Map, 0, len(rows))
+	for _, s := range rows {
+		sessions = append(sessions, fiber.Map{
+			"id":           s.ID,
+			"user_agent":   s.UserAgent,
+			"ip":           s.IP,
+			"created_at":   s.CreatedAt,
+			"last_used_at": s.LastUsedAt,
+			"expires_at":   s.ExpiresAt,
+			"current":      s.ID == currentSessionID,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"sessions": sessions,
+		"total":    len(sessions),
+	})
+}
+
+// RevokeSession logs one of the user's devices out, including the current one
+func RevokeSession(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
+	currentSessionID := c.Locals("session_id").(primitive.ObjectID)
+
+	sessionID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Session not found",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	revoked, err := revokeSession(ctx, sessionID, currentUserID, models.SessionRevokedByUser)
+	if err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID.Hex(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke session",
+		})
+	}
+	if !revoked {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Session not found",
+		})
+	}
+
+	// Revoking the session in use is a logout, its cookies are useless now
+	if sessionID == currentSessionID {
+		clearAuthCookies(c)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Session revoked",
+		"current": sessionID == currentSessionID,
+	})
+}
@@ -3,7 +3,10 @@ package controllers
 
 import (
 	"context"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Adisonsmn/ngobrolyuk/config"
 	"github.com/Adisonsmn/ngobrolyuk/models"
@@ -113,7 +116,7 @@ func ListUsers(c *fiber.Ctx) error {
 
 	// Query parameters
 	online := c.Query("online")
-	search := c.Query("search")
+	search := strings.TrimSpace(c.Query("search"))
 	page := c.QueryInt("page", 1)
 	limit := c.QueryInt("limit", 20)
 
@@ -122,24 +125,49 @@ func ListUsers(c *fiber.Ctx) error {
 	}
 	skip := (page - 1) * limit
 
+	// Users that blocked each other do not see one another
+	blocked, err := blockedUserIDs(context.Background(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch users",
+		})
+	}
+
 	// Build filter
 	filter := bson.M{}
 
-	// Exclude current user
+	// Exclude current user and blocked users
+	excluded := make([]interface{}, 0, len(blocked)+1)
+	for _, id := range blocked {
+		excluded = append(excluded, id)
+	}
 	if objID, err := primitive.ObjectIDFromHex(userID); err == nil {
-		filter["_id"] = bson.M{"$ne": objID}
+		excluded = append(excluded, objID)
 	} else {
-		filter["_id"] = bson.M{"$ne": userID}
+		excluded = append(excluded, userID)
 	}
+	filter["_id"] = bson.M{"$nin": excluded}
 
 	if online == "true" {
 		filter["online"] = true
 	}
-	if search != "" {
-		filter["$or"] = []bson.M{
-			{"username": bson.M{"$regex": search, "$options": "i"}},
-			{"email": bson.M{"$regex": search, "$options": "i"}},
+	if search == "" {
+		// Without a search only contacts are listed, the whole user base is never exposed
+		contacts, err := contactIDs(context.Background(), userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch users",
+			})
+		}
+		filter["_id"] = bson.M{"$in": contacts, "$nin": excluded}
+	} else {
+		if utf8.RuneCountInString(search) < 3 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "search must be at least 3 characters",
+			})
 		}
+		// Username prefix only, escaped so the input is never interpreted as a regex
+		filter["username"] = bson.M{"$regex": "^" + regexp.QuoteMeta(search), "$options": "i"}
 	}
 
 	// ✅ Use bson.D for sorting (ordered)
@@ -185,6 +213,25 @@ func ListUsers(c *fiber.Ctx) error {
 		})
 	}
 
+	// Contact state of every listed user, "" for strangers
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u["id"].(string))
+	}
+	contacts, err := contactsByUser(context.Background(), userID, ids)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch users",
+		})
+	}
+	for _, u := range users {
+		status := ""
+		if contact, ok := contacts[u["id"].(string)]; ok {
+			status = contact.StatusFor(userID)
+		}
+		u["contact_status"] = status
+	}
+
 	// Total count
 	total, _ := config.DB.Collection("users").CountDocuments(context.Background(), filter)
 
@@ -200,10 +247,24 @@ func ListUsers(c *fiber.Ctx) error {
 }
 
 func GetUserProfile(c *fiber.Ctx) error {
+	currentUserID := c.Locals("user_id").(string)
 	userID := c.Params("id")
 
+	// A user who blocked the caller looks like a user that does not exist
+	blockedBy, err := hasBlocked(context.Background(), userID, currentUserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch user",
+		})
+	}
+	if blockedBy {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
 	var user models.User
-	err := config.DB.Collection("users").FindOne(context.Background(),
+	err = config.DB.Collection("users").FindOne(context.Background(),
 		bson.M{"_id": userID}).Decode(&user)
 
 	if err != nil {
@@ -212,6 +273,14 @@ func GetUserProfile(c *fiber.Ctx) error {
 		})
 	}
 
+	// The caller still sees users they blocked, so they can unblock them
+	blocked, err := hasBlocked(context.Background(), currentUserID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch user",
+		})
+	}
+
 	return c.JSON(fiber.Map{
 		"id":        user.ID,
 		"username":  user.Username,
@@ -219,18 +288,34 @@ func GetUserProfile(c *fiber.Ctx) error {
 		"avatar":    user.Avatar,
 		"online":    user.Online,
 		"last_seen": user.LastSeen,
+		"blocked":   blocked,
 	})
 }
 
 func GetOnlineUsers(c *fiber.Ctx) error {
 	currentUserID := c.Locals("user_id").(string)
 
-	// Get users that are online and active within last 5 minutes
+	blocked, err := blockedUserIDs(context.Background(), currentUserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch online users",
+		})
+	}
+
+	// Like ListUsers, only contacts are listed so the whole user base is never exposed
+	contacts, err := contactIDs(context.Background(), currentUserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch online users",
+		})
+	}
+
+	// Get contacts that are online with a recent heartbeat
 	filter := bson.M{
-		"_id":    bson.M{"$ne": currentUserID},
+		"_id":    bson.M{"$in": contacts, "$nin": append(blocked, currentUserID)},
 		"online": true,
-		"last_seen": bson.M{
-			"$gte": time.Now().Add(-5 * time.Minute),
+		"last_active": bson.M{
+			"$gte": time.Now().Add(-presenceStaleAfter),
 		},
 	}
 
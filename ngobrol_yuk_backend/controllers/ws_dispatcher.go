@@ -0,0 +1,412 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// wsError is returned by event handlers and sent back to the client as an error event
+type wsError struct {
+	Code    string
+	Message string
+	Errors  []string
+}
+
+func (e *wsError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// errDeliveryFailed nacks a send that was stored but refused by the hub, the client retries with the same client_msg_id
+var errDeliveryFailed = &wsError{Code: models.ErrCodeDeliveryFailed, Message: "Message saved but could not be delivered, retry with the same client_msg_id"}
+
+type eventHandler func(c *Client, evt models.Event) error
+
+var eventHandlers map[string]eventHandler
+
+func init() {
+	eventHandlers = map[string]eventHandler{
+		models.EventSendMessage: handleSendMessage,
+		models.EventResync:      handleResync,
+		models.EventTypingStart: handleTypingStart,
+		models.EventTypingStop:  handleTypingStop,
+
+		models.EventMessageDelivered: handleMessageDelivered,
+		models.EventMessageRead:      handleMessageRead,
+
+		models.EventEditMessage:   handleEditMessage,
+		models.EventDeleteMessage: handleDeleteMessage,
+
+		models.EventAddReaction:    handleReaction(true),
+		models.EventRemoveReaction: handleReaction(false),
+	}
+}
+
+// dispatch parses a raw frame and routes it to the handler registered for its type
+func (c *Client) dispatch(raw []byte) {
+	var evt models.Event
+	if err := json.Unmarshal(raw, &evt); err != nil || evt.Type == "" {
+		c.replyError("", &wsError{Code: models.ErrCodeInvalidEvent, Message: "Frame is not a valid event envelope"})
+		return
+	}
+
+	if evt.Version > models.EventProtocolVersion {
+		c.replyError(evt.ID, &wsError{Code: models.ErrCodeUnsupportedVersion, Message: "Unsupported protocol version"})
+		return
+	}
+
+	handler, ok := eventHandlers[evt.Type]
+	if !ok {
+		c.replyError(evt.ID, &wsError{Code: models.ErrCodeUnknownType, Message: "Unknown event type: " + evt.Type})
+		return
+	}
+
+	if err := handler(c, evt); err != nil {
+		c.replyError(evt.ID, err)
+	}
+}
+
+// reply queues an event for this device only
+func (c *Client) reply(evt models.Event) {
+	select {
+	case hub.Broadcast <- Delivery{Client: c, Event: evt}:
+	case <-time.After(5 * time.Second):
+		log.Printf("Broadcast channel full, reply dropped for user %s", c.UserID)
+	}
+}
+
+func (c *Client) replyError(id string, err error) {
+	wsErr, ok := err.(*wsError)
+	if !ok {
+		log.Printf("Event handler failed for user %s: %v", c.UserID, err)
+		wsErr = &wsError{Code: models.ErrCodeInternal, Message: "Internal server error"}
+	}
+
+	evt, _ := models.NewEvent(models.EventError, id, models.ErrorPayload{
+		Code:    wsErr.Code,
+		Message: wsErr.Message,
+		Errors:  wsErr.Errors,
+	})
+	c.reply(evt)
+}
+
+// groupEventError maps loadGroupForMember errors to an event error, like groupLookupError does for HTTP
+func groupEventError(err error) error {
+	switch err {
+	case errGroupNotFound:
+		return &wsError{Code: models.ErrCodeNotFound, Message: "Group not found"}
+	case errNotGroupMember:
+		return &wsError{Code: models.ErrCodeForbidden, Message: "You are not a member of this group"}
+	default:
+		return err
+	}
+}
+
+// broadcastEvent wraps payload in an envelope and fans it out to every device of userIDs.
+// It reports whether the event was queued on the hub.
+func broadcastEvent(userIDs []string, eventType string, payload interface{}) bool {
+	evt, err := models.NewEvent(eventType, "", payload)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", eventType, err)
+		return false
+	}
+
+	select {
+	case hub.Broadcast <- Delivery{UserIDs: userIDs, Event: evt}:
+		log.Printf("Event %s broadcast to hub for %d recipients", eventType, len(userIDs))
+		return true
+	case <-time.After(5 * time.Second):
+		log.Printf("Broadcast channel full, %s event dropped", eventType)
+		return false
+	}
+}
+
+// handleSendMessage always answers the sending device with an ack or a nack
+func handleSendMessage(c *Client, evt models.Event) error {
+	var msgReq models.SendMessageRequest
+	if err := evt.DecodePayload(&msgReq); err != nil {
+		return &wsError{Code: models.ErrCodeInvalidEvent, Message: "Invalid send_message payload"}
+	}
+
+	ack, err := sendMessage(c, &msgReq)
+	if err != nil {
+		wsErr, ok := err.(*wsError)
+		if !ok {
+			log.Printf("Failed to send message for user %s: %v", c.UserID, err)
+			wsErr = &wsError{Code: models.ErrCodeInternal, Message: "Message could not be saved"}
+		}
+
+		nack, _ := models.NewEvent(models.EventNack, evt.ID, models.NackPayload{
+			ClientMsgID: msgReq.ClientMsgID,
+			Code:        wsErr.Code,
+			Reason:      wsErr.Message,
+			Errors:      wsErr.Errors,
+		})
+		c.reply(nack)
+		return nil
+	}
+
+	reply, _ := models.NewEvent(models.EventAck, evt.ID, ack)
+	c.reply(reply)
+	return nil
+}
+
+func sendMessage(c *Client, msgReq *models.SendMessageRequest) (*models.AckPayload, error) {
+	log.Printf("Message received from user %s: %s", c.UserID, msgReq.Content)
+
+	// Validate message
+	if validationErrors := msgReq.Validate(); len(validationErrors) > 0 {
+		return nil, &wsError{Code: models.ErrCodeValidationFailed, Message: "Validation failed", Errors: validationErrors}
+	}
+
+	// Create message
+	message := models.Message{
+		ID:          primitive.NewObjectID(),
+		ClientMsgID: msgReq.ClientMsgID,
+		SenderID:    c.UserID,
+		Content:     msgReq.Content,
+		Type:        msgReq.Type,
+		Read:        false,
+		Status:      models.MessageStatusSent,
+		CreatedAt:   time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Resolve recipients; the sender always gets a copy for confirmation
+	var recipients []string
+	if msgReq.ConversationID != "" {
+		group, err := loadGroupForMember(ctx, msgReq.ConversationID, c.UserID)
+		if err != nil {
+			return nil, groupEventError(err)
+		}
+
+		message.ConversationID = &group.ID
+		recipients = group.MemberIDs()
+	} else {
+		// Prevent self-messaging
+		if msgReq.ReceiverID == c.UserID {
+			return nil, &wsError{Code: models.ErrCodeValidationFailed, Message: "You cannot send a message to yourself"}
+		}
+
+		// Nothing is stored or delivered between users that blocked each other
+		blocked, err := isBlocked(ctx, c.UserID, msgReq.ReceiverID)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, &wsError{Code: models.ErrCodeForbidden, Message: "You cannot message this user"}
+		}
+
+		message.ReceiverID = msgReq.ReceiverID
+		recipients = []string{msgReq.ReceiverID, c.UserID}
+	}
+
+	if msgReq.MediaID != "" {
+		attachment, err := loadAttachment(ctx, msgReq.MediaID, c.UserID, msgReq.Type)
+		if err != nil {
+			return nil, err
+		}
+		message.Attachment = attachment
+	}
+
+	if msgReq.ReplyTo != "" {
+		quoted, err := loadQuotedMessage(ctx, msgReq.ReplyTo, &message)
+		if err != nil {
+			return nil, err
+		}
+		message.ReplyTo = &quoted.ID
+		message.ReplyPreview = quoted.Preview()
+	}
+
+	// Save to database dengan timeout
+	if _, err := config.DB.Collection("messages").InsertOne(ctx, message); err != nil {
+		if message.ClientMsgID != "" && mongo.IsDuplicateKeyError(err) {
+			return ackExisting(ctx, c.UserID, message.ClientMsgID)
+		}
+		return nil, err
+	}
+
+	log.Printf("Message saved to database from user %s", c.UserID)
+
+	// Writing in a direct chat takes it out of the sender's message requests for good
+	if message.ConversationID == nil {
+		if err := acceptChat(ctx, c.UserID, message.ReceiverID); err != nil {
+			log.Printf("Failed to accept chat of user %s with %s: %v", c.UserID, message.ReceiverID, err)
+		}
+	}
+
+	// Sending a message ends the typing indicator for that chat, keyed like handleTypingStart does
+	var typingGroupID string
+	if message.ConversationID != nil {
+		typingGroupID = message.ConversationID.Hex()
+	}
+	typing.stop(typingKeyFor(c, message.ReceiverID, typingGroupID))
+
+	if message.ConversationID != nil {
+		go touchGroup(*message.ConversationID, message.CreatedAt)
+	}
+
+	// Update user's last seen
+	go func(userID string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := config.DB.Collection("users").UpdateOne(ctx,
+			bson.M{"_id": userID},
+			bson.M{"$set": bson.M{"last_seen": time.Now()}},
+		)
+		if err != nil {
+			log.Printf("Failed to update last_seen for user %s: %v", userID, err)
+		}
+	}(c.UserID)
+
+	delivered := deliverMessage(ctx, &message, recipients)
+
+	ack := &models.AckPayload{
+		ClientMsgID: message.ClientMsgID,
+		MessageID:   message.ID,
+		CreatedAt:   message.CreatedAt,
+	}
+
+	if !delivered {
+		if message.ClientMsgID != "" {
+			// Remembered so the retry redelivers the stored message instead of only acking it
+			_, err := config.DB.Collection("messages").UpdateOne(ctx,
+				bson.M{"_id": message.ID},
+				bson.M{"$set": bson.M{"undelivered": true}},
+			)
+			if err == nil {
+				return nil, errDeliveryFailed
+			}
+			log.Printf("Failed to mark message %s undelivered: %v", message.ID.Hex(), err)
+		}
+		// Without a client_msg_id a retry would store a duplicate and without the flag it would not
+		// redeliver, so the saved message is acked with a warning instead
+		ack.Warning = models.ErrCodeDeliveryFailed
+	}
+
+	return ack, nil
+}
+
+// deliverMessage broadcasts a stored message to recipients and reports whether the hub took it.
+// A direct message from a non-contact lands in the receiver's requests inbox, the sender sees a normal message.
+func deliverMessage(ctx context.Context, message *models.Message, recipients []string) bool {
+	delivered := true
+
+	if message.ConversationID == nil {
+		request, err := isMessageRequest(ctx, message.ReceiverID, message.SenderID)
+		if err != nil {
+			log.Printf("Failed to check message request for user %s: %v", message.ReceiverID, err)
+		}
+		if request {
+			recipients = []string{message.SenderID}
+			delivered = broadcastEvent([]string{message.ReceiverID}, models.EventMessageRequest, message)
+		}
+	}
+
+	if !broadcastEvent(recipients, models.EventMessage, message) {
+		delivered = false
+	}
+	return delivered
+}
+
+// loadQuotedMessage makes sure replyTo points at a live message of the same conversation as reply
+func loadQuotedMessage(ctx context.Context, replyTo string, reply *models.Message) (*models.Message, error) {
+	objID, _ := primitive.ObjectIDFromHex(replyTo)
+
+	filter := bson.M{
+		"_id":        objID,
+		"deleted_at": bson.M{"$exists": false},
+		"hidden_for": bson.M{"$ne": reply.SenderID},
+	}
+	if reply.ConversationID != nil {
+		filter["conversation_id"] = *reply.ConversationID
+	} else {
+		filter["$or"] = []bson.M{
+			{"sender_id": reply.SenderID, "receiver_id": reply.ReceiverID},
+			{"sender_id": reply.ReceiverID, "receiver_id": reply.SenderID},
+		}
+	}
+
+	var quoted models.Message
+	err := config.DB.Collection("messages").FindOne(ctx, filter).Decode(&quoted)
+	if err == mongo.ErrNoDocuments {
+		return nil, &wsError{Code: models.ErrCodeValidationFailed, Message: "reply_to must reference a message in the same conversation"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quoted, nil
+}
+
+// ackExisting answers a retried send with the message stored by the earlier attempt.
+// When that attempt could not be delivered the stored message is delivered now.
+func ackExisting(ctx context.Context, senderID, clientMsgID string) (*models.AckPayload, error) {
+	var existing models.Message
+	err := config.DB.Collection("messages").FindOne(ctx,
+		bson.M{"sender_id": senderID, "client_msg_id": clientMsgID}).Decode(&existing)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Duplicate send %s from user %s, acking message %s", clientMsgID, senderID, existing.ID.Hex())
+
+	if existing.Undelivered {
+		if err := redeliverMessage(ctx, &existing); err != nil {
+			return nil, err
+		}
+	}
+
+	return &models.AckPayload{
+		ClientMsgID: clientMsgID,
+		MessageID:   existing.ID,
+		CreatedAt:   existing.CreatedAt,
+		Duplicate:   true,
+	}, nil
+}
+
+// redeliverMessage broadcasts a message the hub refused when it was sent
+func redeliverMessage(ctx context.Context, message *models.Message) error {
+	// Deleted for everyone in the meantime, the tombstone already reached the recipients
+	if message.DeletedAt != nil {
+		return nil
+	}
+
+	var recipients []string
+	if message.ConversationID != nil {
+		group, err := loadGroupForMember(ctx, message.ConversationID.Hex(), message.SenderID)
+		if err != nil {
+			return groupEventError(err)
+		}
+		recipients = group.MemberIDs()
+	} else {
+		recipients = []string{message.ReceiverID, message.SenderID}
+	}
+
+	batch := []models.Message{*message}
+	attachReplyPreviews(ctx, batch)
+
+	if !deliverMessage(ctx, &batch[0], recipients) {
+		return errDeliveryFailed
+	}
+
+	_, err := config.DB.Collection("messages").UpdateOne(ctx,
+		bson.M{"_id": message.ID},
+		bson.M{"$unset": bson.M{"undelivered": ""}},
+	)
+	if err != nil {
+		log.Printf("Failed to clear undelivered flag of message %s: %v", message.ID.Hex(), err)
+	}
+
+	log.Printf("Message %s redelivered on retry", message.ID.Hex())
+	return nil
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	resyncBatchSize   = 100
+	resyncMaxMessages = 5000
+)
+
+// userGroupIDs returns the IDs of every group userID currently belongs to
+func userGroupIDs(ctx context.Context, userID string) ([]primitive.ObjectID, error) {
+	cursor, err := config.DB.Collection("conversations").Find(ctx,
+		bson.M{"type": models.ConversationTypeGroup, "members.user_id": userID},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var ids []primitive.ObjectID
+	for cursor.Next(ctx) {
+		var g struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&g); err == nil {
+			ids = append(ids, g.ID)
+		}
+	}
+	return ids, cursor.Err()
+}
+
+// userMessagesFilter matches every message visible to userID across direct chats and groups
+func userMessagesFilter(userID string, groupIDs []primitive.ObjectID) bson.M {
+	or := []bson.M{
+		{"sender_id": userID},
+		{"receiver_id": userID},
+	}
+	if len(groupIDs) > 0 {
+		or = append(or, bson.M{"conversation_id": bson.M{"$in": groupIDs}})
+	}
+	return bson.M{"$or": or, "hidden_for": bson.M{"$ne": userID}}
+}
+
+// handleResync streams every message the device missed, then switches it to live delivery.
+// Live events arriving meanwhile are held by the hub and flushed after resync_complete.
+func handleResync(c *Client, evt models.Event) error {
+	var req models.ResyncRequest
+	if err := evt.DecodePayload(&req); err != nil {
+		return &wsError{Code: models.ErrCodeInvalidEvent, Message: "Invalid resync payload"}
+	}
+
+	if validationErrors := req.Validate(); len(validationErrors) > 0 {
+		return &wsError{Code: models.ErrCodeValidationFailed, Message: "Validation failed", Errors: validationErrors}
+	}
+
+	hub.mu.Lock()
+	c.syncing = true
+	hub.mu.Unlock()
+
+	complete, err := streamMissedMessages(c, evt.ID, &req)
+	if err != nil {
+		log.Printf("Resync failed for user %s: %v", c.UserID, err)
+		c.replyError(evt.ID, err)
+		if complete == nil {
+			complete = &models.ResyncCompletePayload{}
+		}
+	}
+
+	done, _ := models.NewEvent(models.EventResyncComplete, evt.ID, complete)
+	select {
+	case hub.Broadcast <- Delivery{Client: c, Event: done, EndSync: true}:
+	case <-time.After(5 * time.Second):
+		// Never leave the device stuck in syncing mode
+		log.Printf("Broadcast channel full, ending resync for user %s without completion event", c.UserID)
+		hub.mu.Lock()
+		c.syncing = false
+		c.pending = nil
+		hub.mu.Unlock()
+	}
+
+	return nil
+}
+
+func streamMissedMessages(c *Client, eventID string, req *models.ResyncRequest) (*models.ResyncCompletePayload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	groupIDs, err := userGroupIDs(ctx, c.UserID)
+	if err != nil {
+		return nil, err
+	}
+	visible := userMessagesFilter(c.UserID, groupIDs)
+
+	// The cursor is the last message the device has, ordered by (created_at, _id), or only a time
+	var newer bson.M
+	var cursorAt time.Time
+	if req.LastMessageID != "" {
+		last, _, err := loadMessageForParticipant(ctx, req.LastMessageID, c.UserID)
+		switch err {
+		case nil:
+		case errMessageNotFound, errGroupNotFound, errNotGroupMember:
+			return nil, &wsError{Code: models.ErrCodeNotFound, Message: "last_message_id not found"}
+		default:
+			return nil, err
+		}
+
+		cursorAt = last.CreatedAt
+		newer = bson.M{"$or": []bson.M{
+			{"created_at": bson.M{"$gt": last.CreatedAt}},
+			{"created_at": last.CreatedAt, "_id": bson.M{"$gt": last.ID}},
+		}}
+	} else {
+		cursorAt = *req.Since
+		newer = bson.M{"created_at": bson.M{"$gt": cursorAt}}
+	}
+
+	complete := &models.ResyncCompletePayload{}
+	batch := make([]models.Message, 0, resyncBatchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		attachReplyPreviews(ctx, batch)
+		out, _ := models.NewEvent(models.EventResyncBatch, eventID, models.ResyncBatchPayload{Messages: batch})
+		c.reply(out)
+		batch = make([]models.Message, 0, resyncBatchSize)
+	}
+
+	add := func(message models.Message) {
+		batch = append(batch, message)
+		complete.Count++
+		if len(batch) == resyncBatchSize {
+			flush()
+		}
+	}
+
+	// First the messages the device already has that were edited, deleted or reacted to since.
+	// They do not move the cursor, so they are not capped; there are only as many as changed meanwhile.
+	changed := bson.M{"$and": []bson.M{
+		visible,
+		{"updated_at": bson.M{"$gt": cursorAt}},
+		{"$nor": []bson.M{newer}},
+	}}
+	err = eachMessage(ctx, changed, 0, func(message models.Message) bool {
+		add(message)
+		return true
+	})
+	if err != nil {
+		flush()
+		return complete, err
+	}
+
+	// Then everything sent after the cursor, in their current state
+	streamed := 0
+	err = eachMessage(ctx, bson.M{"$and": []bson.M{visible, newer}}, resyncMaxMessages+1, func(message models.Message) bool {
+		if streamed == resyncMaxMessages {
+			complete.HasMore = true
+			return false
+		}
+		add(message)
+		streamed++
+		complete.LastMessageID = &message.ID
+		return true
+	})
+	flush()
+	if err != nil {
+		return complete, err
+	}
+
+	log.Printf("Resync streamed %d messages to user %s", complete.Count, c.UserID)
+	return complete, nil
+}
+
+// eachMessage calls fn for the messages matching filter in (created_at, _id) order until fn returns false.
+// A limit of 0 means no limit.
+func eachMessage(ctx context.Context, filter bson.M, limit int64, fn func(models.Message) bool) error {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(resyncBatchSize)
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := config.DB.Collection("messages").Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil {
+			log.Printf("Failed to decode message during resync: %v", err)
+			continue
+		}
+		if !fn(message) {
+			break
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Adisonsmn/ngobrolyuk/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// typingTTL is how long a typing_start stays active without being refreshed
+const typingTTL = 6 * time.Second
+
+type typingKey struct {
+	client *Client
+	target string // "user:<id>" or "group:<id>"
+}
+
+type typingEntry struct {
+	timer      *time.Timer
+	armed      uint64 // Sequence of the timer currently armed, older callbacks are ignored
+	recipients []string
+	payload    models.TypingPayload
+}
+
+// typingTracker keeps ephemeral typing state in memory only, nothing is stored in MongoDB
+type typingTracker struct {
+	mu      sync.Mutex
+	entries map[typingKey]*typingEntry
+	seq     uint64
+}
+
+var typing = &typingTracker{
+	entries: make(map[typingKey]*typingEntry),
+}
+
+// start (re)arms the expiry timer and relays typing_start to the recipients
+func (t *typingTracker) start(key typingKey, recipients []string, payload models.TypingPayload) {
+	t.mu.Lock()
+	entry, ok := t.entries[key]
+	if ok {
+		entry.timer.Stop()
+		entry.recipients = recipients
+	} else {
+		entry = &typingEntry{recipients: recipients, payload: payload}
+		t.entries[key] = entry
+	}
+
+	// A timer that already fired may still run its callback, a fresh sequence makes it a no-op
+	t.seq++
+	armed := t.seq
+	entry.armed = armed
+	entry.timer = time.AfterFunc(typingTTL, func() { t.expire(key, armed) })
+	t.mu.Unlock()
+
+	payload.ExpiresInMs = typingTTL.Milliseconds()
+	broadcastEvent(recipients, models.EventTypingStart, payload)
+}
+
+func (t *typingTracker) stop(key typingKey) {
+	t.mu.Lock()
+	entry, ok := t.entries[key]
+	if ok {
+		entry.timer.Stop()
+		delete(t.entries, key)
+	}
+	t.mu.Unlock()
+
+	if ok {
+		broadcastEvent(entry.recipients, models.EventTypingStop, entry.payload)
+	}
+}
+
+// expire fires when a client never sent typing_stop, e.g. because it crashed.
+// It only stops the entry that armed this timer, not one started after a stop.
+func (t *typingTracker) expire(key typingKey, armed uint64) {
+	t.mu.Lock()
+	entry, ok := t.entries[key]
+	ok = ok && entry.armed == armed
+	if ok {
+		delete(t.entries, key)
+	}
+	t.mu.Unlock()
+
+	if ok {
+		log.Printf("Typing indicator of user %s on %s expired", key.client.UserID, key.target)
+		broadcastEvent(entry.recipients, models.EventTypingStop, entry.payload)
+	}
+}
+
+// clearClient stops every indicator owned by a disconnecting device
+func (t *typingTracker) clearClient(c *Client) {
+	t.mu.Lock()
+	var keys []typingKey
+	for key := range t.entries {
+		if key.client == c {
+			keys = append(keys, key)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, key := range keys {
+		t.stop(key)
+	}
+}
+
+func decodeTyping(evt models.Event) (*models.TypingRequest, error) {
+	var req models.TypingRequest
+	if err := evt.DecodePayload(&req); err != nil {
+		return nil, &wsError{Code: models.ErrCodeInvalidEvent, Message: "Invalid typing payload"}
+	}
+
+	if validationErrors := req.Validate(); len(validationErrors) > 0 {
+		return nil, &wsError{Code: models.ErrCodeValidationFailed, Message: "Validation failed", Errors: validationErrors}
+	}
+
+	return &req, nil
+}
+
+func typingKeyFor(c *Client, receiverID, conversationID string) typingKey {
+	if conversationID != "" {
+		return typingKey{client: c, target: "group:" + conversationID}
+	}
+	return typingKey{client: c, target: "user:" + receiverID}
+}
+
+func handleTypingStart(c *Client, evt models.Event) error {
+	req, err := decodeTyping(evt)
+	if err != nil {
+		return err
+	}
+
+	payload := models.TypingPayload{UserID: c.UserID}
+	var recipients []string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if req.ReceiverID != "" {
+		if req.ReceiverID == c.UserID {
+			return &wsError{Code: models.ErrCodeValidationFailed, Message: "You cannot type to yourself"}
+		}
+
+		blocked, err := isBlocked(ctx, c.UserID, req.ReceiverID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return &wsError{Code: models.ErrCodeForbidden, Message: "You cannot message this user"}
+		}
+
+		payload.ReceiverID = req.ReceiverID
+		recipients = []string{req.ReceiverID}
+	} else {
+		group, err := loadGroupForMember(ctx, req.ConversationID, c.UserID)
+		if err != nil {
+			return groupEventError(err)
+		}
+
+		payload.ConversationID = group.ID.Hex()
+		for _, id := range group.MemberIDs() {
+			if id != c.UserID {
+				recipients = append(recipients, id)
+			}
+		}
+	}
+
+	typing.start(typingKeyFor(c, payload.ReceiverID, payload.ConversationID), recipients, payload)
+	return nil
+}
+
+// handleTypingStop needs no lookup, an unknown indicator is simply ignored
+func handleTypingStop(c *Client, evt models.Event) error {
+	req, err := decodeTyping(evt)
+	if err != nil {
+		return err
+	}
+
+	// Same key as handleTypingStart, which uses the canonical hex of the group ID
+	conversationID := req.ConversationID
+	if groupID, err := primitive.ObjectIDFromHex(conversationID); err == nil {
+		conversationID = groupID.Hex()
+	}
+
+	typing.stop(typingKeyFor(c, req.ReceiverID, conversationID))
+	return nil
+}
@@ -0,0 +1,147 @@
+// Package denylist keeps revoked access tokens and sessions in memory so every
+// authenticated request can be checked without a database round trip.
+package denylist
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds the entries; a TTL index removes them once the tokens they cover have expired
+const Collection = "token_denylist"
+
+const (
+	KindToken   = "token"   // ID is the jti of one access token
+	KindSession = "session" // ID is a session, every access token carrying it as sid is denied
+)
+
+const (
+	// syncInterval bounds how long a revocation on another replica takes to reach this one
+	syncInterval = 5 * time.Second
+	// syncOverlap re-reads recent entries to absorb clock skew between replicas
+	syncOverlap = 10 * time.Second
+)
+
+type Entry struct {
+	ID        string    `bson:"_id"`
+	Kind      string    `bson:"kind"`
+	UserID    string    `bson:"user_id"`
+	Reason    string    `bson:"reason"`
+	CreatedAt time.Time `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"` // When the last token covered by the entry expires
+}
+
+var (
+	mu         sync.RWMutex
+	entries    = make(map[string]time.Time) // ID -> ExpiresAt
+	collection *mongo.Collection
+)
+
+// Start loads the unexpired entries and keeps polling for entries added by other replicas
+// until ctx is cancelled. It must be called once at startup, before the server accepts requests.
+func Start(ctx context.Context, db *mongo.Database) error {
+	collection = db.Collection(Collection)
+
+	since := time.Now()
+	if err := load(ctx, bson.M{"expires_at": bson.M{"$gt": since}}); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				now := time.Now()
+				if err := load(ctx, bson.M{"created_at": bson.M{"$gte": since.Add(-syncOverlap)}}); err != nil {
+					log.Printf("Failed to sync token denylist: %v", err)
+					continue
+				}
+				since = now
+				prune(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	log.Printf("Token denylist loaded with %d entries", Len())
+	return nil
+}
+
+// Add stores entry and makes it effective on this replica right away
+func Add(ctx context.Context, entry Entry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": entry.ID}, entry, options.Replace().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+
+	remember(entry.ID, entry.ExpiresAt)
+	return nil
+}
+
+// Contains reports whether id, a jti or a session ID, has been denied. It never touches the database.
+func Contains(id string) bool {
+	mu.RLock()
+	expiresAt, ok := entries[id]
+	mu.RUnlock()
+	return ok && time.Now().Before(expiresAt)
+}
+
+func Len() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(entries)
+}
+
+func load(ctx context.Context, filter bson.M) error {
+	loadCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(loadCtx, filter,
+		options.Find().SetProjection(bson.M{"_id": 1, "expires_at": 1}))
+	if err != nil {
+		return err
+	}
+
+	var loaded []Entry
+	if err := cursor.All(loadCtx, &loaded); err != nil {
+		return err
+	}
+
+	for _, e := range loaded {
+		remember(e.ID, e.ExpiresAt)
+	}
+	return nil
+}
+
+func remember(id string, expiresAt time.Time) {
+	mu.Lock()
+	if expiresAt.After(entries[id]) {
+		entries[id] = expiresAt
+	}
+	mu.Unlock()
+}
+
+// prune forgets entries whose tokens have expired on their own
+func prune(now time.Time) {
+	mu.Lock()
+	for id, expiresAt := range entries {
+		if !now.Before(expiresAt) {
+			delete(entries, id)
+		}
+	}
+	mu.Unlock()
+}
@@ -0,0 +1,103 @@
+package imaging
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// blurhash encodes img following https://github.com/woltapp/blurhash, so any
+// client side blurhash decoder can render the placeholder
+func blurhash(img *image.RGBA, xComponents, yComponents int) string {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for y := 0; y < yComponents; y++ {
+		for x := 0; x < xComponents; x++ {
+			normalisation := 2.0
+			if x == 0 && y == 0 {
+				normalisation = 1
+			}
+
+			var r, g, bl float64
+			for py := 0; py < height; py++ {
+				for px := 0; px < width; px++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(x)*float64(px)/float64(width)) *
+						math.Cos(math.Pi*float64(y)*float64(py)/float64(height))
+
+					c := img.RGBAAt(b.Min.X+px, b.Min.Y+py)
+					r += basis * srgbToLinear(c.R)
+					g += basis * srgbToLinear(c.G)
+					bl += basis * srgbToLinear(c.B)
+				}
+			}
+
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{r * scale, g * scale, bl * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			for _, v := range f {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encode83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	hash.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+
+	return hash.String()
+}
+
+func encode83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(out)
+}
+
+func srgbToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,160 @@
+// Package imaging prepares uploaded images: it removes embedded metadata such as
+// EXIF GPS tags, measures the image and renders a thumbnail plus a blurhash placeholder.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // Registered for image.Decode
+	"image/jpeg"
+	_ "image/png" // Registered for image.Decode
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registered for image.Decode
+)
+
+const (
+	// ThumbnailSize is the longest side of a thumbnail in pixels
+	ThumbnailSize = 320
+	// ThumbnailContentType is the format thumbnails are encoded in
+	ThumbnailContentType = "image/jpeg"
+
+	// maxPixels guards against decompression bombs, a tiny file can declare a huge canvas
+	maxPixels = 40_000_000
+
+	blurhashComponentsX = 4
+	blurhashComponentsY = 3
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported image format")
+	ErrTooLarge    = errors.New("image dimensions too large")
+)
+
+// Result is a processed image ready to be stored
+type Result struct {
+	Data        []byte // The original image without metadata
+	Width       int
+	Height      int
+	Thumbnail   []byte // JPEG, at most ThumbnailSize on the longest side
+	ThumbWidth  int
+	ThumbHeight int
+	Blurhash    string
+}
+
+// Process strips metadata from data, applies the EXIF orientation and renders the previews.
+// contentType must be the sniffed type of data.
+func Process(data []byte, contentType string) (*Result, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	orientation := 1
+	if contentType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+
+	cleaned, err := StripMetadata(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(cleaned))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	// Removing EXIF also removes the orientation tag, so rotated photos are re-encoded upright
+	if orientation > 1 {
+		img = orient(img, orientation)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, err
+		}
+		cleaned = buf.Bytes()
+	}
+
+	bounds := img.Bounds()
+	thumb := scale(img, ThumbnailSize)
+
+	var thumbBuf bytes.Buffer
+	if err := jpeg.Encode(&thumbBuf, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Data:        cleaned,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Thumbnail:   thumbBuf.Bytes(),
+		ThumbWidth:  thumb.Bounds().Dx(),
+		ThumbHeight: thumb.Bounds().Dy(),
+		Blurhash:    blurhash(scale(thumb, 32), blurhashComponentsX, blurhashComponentsY),
+	}, nil
+}
+
+// scale fits img into a maxSize square on a white background, JPEG has no transparency
+func scale(img image.Image, maxSize int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	if w > maxSize || h > maxSize {
+		if w >= h {
+			h = max(1, h*maxSize/w)
+			w = maxSize
+		} else {
+			w = max(1, w*maxSize/h)
+			h = maxSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// orient applies an EXIF orientation (2-8) so the image displays upright without the tag
+func orient(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // Rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // Transposed
+				sx, sy = y, x
+			case 6: // Rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // Transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // Rotated 90° counter-clockwise
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,143 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodeJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0x80, 0xFF})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// afterSOF inserts extra right after the frame header
+func afterSOF(t *testing.T, data, extra []byte) []byte {
+	t.Helper()
+
+	i := bytes.Index(data, []byte{0xFF, 0xC0})
+	if i < 0 {
+		t.Fatal("no SOF0 marker in encoded JPEG")
+	}
+	end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+
+	out := append([]byte{}, data[:end]...)
+	out = append(out, extra...)
+	return append(out, data[end:]...)
+}
+
+// pngHeader is a PNG holding only a valid IHDR chunk declaring w x h
+func pngHeader(w, h uint32) []byte {
+	ihdr := make([]byte, 4+13)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], w)
+	binary.BigEndian.PutUint32(ihdr[8:], h)
+	ihdr[12] = 8 // Bit depth
+	ihdr[13] = 2 // Truecolor
+
+	out := []byte("\x89PNG\r\n\x1a\n")
+	out = binary.BigEndian.AppendUint32(out, 13)
+	out = append(out, ihdr...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestProcess(t *testing.T) {
+	photo := encodeJPEG(t, 640, 480)
+	rotated := jpegOf(segment(0xE1, exifOrientation(binary.LittleEndian, 6)), photo[2:])
+
+	var small bytes.Buffer
+	if err := png.Encode(&small, image.NewRGBA(image.Rect(0, 0, 10, 5))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		width       int
+		height      int
+		thumbWidth  int
+		thumbHeight int
+	}{
+		{"landscape JPEG", photo, "image/jpeg", 640, 480, 320, 240},
+		{"EXIF rotated JPEG", rotated, "image/jpeg", 480, 640, 240, 320},
+		{"small PNG is not upscaled", small.Bytes(), "image/png", 10, 5, 10, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Process(tt.data, tt.contentType)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if res.Width != tt.width || res.Height != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", res.Width, res.Height, tt.width, tt.height)
+			}
+			if res.ThumbWidth != tt.thumbWidth || res.ThumbHeight != tt.thumbHeight {
+				t.Errorf("thumbnail size = %dx%d, want %dx%d", res.ThumbWidth, res.ThumbHeight, tt.thumbWidth, tt.thumbHeight)
+			}
+			if bytes.Contains(res.Data, []byte("Exif\x00\x00")) {
+				t.Error("EXIF left in processed image")
+			}
+
+			thumb, err := jpeg.DecodeConfig(bytes.NewReader(res.Thumbnail))
+			if err != nil {
+				t.Fatalf("thumbnail is not a JPEG: %v", err)
+			}
+			if thumb.Width != tt.thumbWidth || thumb.Height != tt.thumbHeight {
+				t.Errorf("encoded thumbnail = %dx%d, want %dx%d", thumb.Width, thumb.Height, tt.thumbWidth, tt.thumbHeight)
+			}
+			if len(res.Blurhash) != 6+2*(blurhashComponentsX*blurhashComponentsY-1) {
+				t.Errorf("blurhash %q has the wrong length", res.Blurhash)
+			}
+		})
+	}
+}
+
+func TestProcessRejectsBrokenInput(t *testing.T) {
+	photo := encodeJPEG(t, 64, 48)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		want        error
+	}{
+		{"empty", nil, "image/jpeg", ErrUnsupported},
+		{"not an image", []byte("hello world"), "image/jpeg", ErrUnsupported},
+		{"truncated header", photo[:20], "image/jpeg", ErrUnsupported},
+		{"zero length segment after frame", afterSOF(t, photo, []byte{0xFF, 0xE1, 0x00, 0x00}), "image/jpeg", ErrUnsupported},
+		{"one byte length segment after frame", afterSOF(t, photo, []byte{0xFF, 0xE1, 0x00, 0x01}), "image/jpeg", ErrUnsupported},
+		{"segment past end after frame", afterSOF(t, photo[:len(photo)/2], []byte{0xFF, 0xE1, 0xFF, 0xFF}), "image/jpeg", ErrUnsupported},
+		{"truncated scan data", photo[:len(photo)-len(photo)/3], "image/jpeg", ErrUnsupported},
+		{"decompression bomb", pngHeader(100_000, 100_000), "image/png", ErrTooLarge},
+		{"zero sized PNG", pngHeader(0, 0), "image/png", ErrUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Process(tt.data, tt.contentType)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Process() = %v, %v, want error %v", res, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("malformed image")
+
+// StripMetadata removes EXIF (including GPS location), XMP and text metadata while
+// leaving the pixel data untouched. GIF carries no such metadata and is returned as is.
+func StripMetadata(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	case "image/gif":
+		return data, nil
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// stripJPEG drops APP1 (EXIF, XMP), APP13 (IPTC) and comment segments
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+
+	for i := 2; i+1 < len(data); {
+		if data[i] != 0xFF {
+			return nil, errMalformed
+		}
+		marker := data[i+1]
+
+		// Padding bytes and markers without a payload
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, errMalformed
+		}
+		// The length counts its own two bytes, anything shorter is corrupt
+		segLen := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + segLen
+		if segLen < 2 || end > len(data) {
+			return nil, errMalformed
+		}
+
+		// Start of scan: the entropy-coded image data follows, keep everything
+		if marker == 0xDA {
+			return append(out, data[i:]...), nil
+		}
+
+		if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	return nil, errMalformed
+}
+
+// stripPNG drops the eXIf chunk and every textual or timestamp chunk
+func stripPNG(data []byte) ([]byte, error) {
+	const signatureLen = 8
+	if len(data) < signatureLen {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:signatureLen]...)
+
+	for i := signatureLen; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length // length, type, data, CRC
+		if end > len(data) {
+			return nil, errMalformed
+		}
+
+		switch chunkType {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return out, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears their flags in the VP8X header
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size
+		if end > len(data) {
+			return nil, errMalformed
+		}
+		// Chunks are padded to an even size, a missing final padding byte is tolerated
+		if size%2 == 1 && end < len(data) {
+			end++
+		}
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if size > 0 {
+				out[start+8] &^= 0x08 | 0x04 // EXIF and XMP present flags
+			}
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// jpegOrientation reads the EXIF orientation tag, 1 (upright) when it is missing
+func jpegOrientation(data []byte) int {
+	exifHeader := []byte("Exif\x00\x00")
+
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		segLen := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + segLen
+		if marker == 0xDA || segLen < 2 || end > len(data) {
+			break
+		}
+
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifHeader) {
+			return tiffOrientation(segment[len(exifHeader):])
+		}
+		i = end
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < count; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			break
+		}
+	}
+	return 1
+}
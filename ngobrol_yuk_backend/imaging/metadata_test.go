@@ -0,0 +1,122 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// segment builds a JPEG marker segment whose length field covers payload
+func segment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// exifOrientation builds an APP1 EXIF payload holding only the orientation tag
+func exifOrientation(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3) // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+func jpegOf(parts ...[]byte) []byte {
+	out := []byte{0xFF, 0xD8}
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+var (
+	app0 = segment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+	sos  = append(segment(0xDA, []byte{1, 1, 0, 0, 0x3F, 0}), 0x12, 0x34, 0xFF, 0xD9)
+)
+
+func TestJPEGOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"empty", nil, 1},
+		{"only SOI", jpegOf(), 1},
+		{"truncated length", jpegOf([]byte{0xFF, 0xE1, 0x00}), 1},
+		{"zero length segment", jpegOf([]byte{0xFF, 0xE1, 0x00, 0x00}, sos), 1},
+		{"one byte length segment", jpegOf([]byte{0xFF, 0xE1, 0x00, 0x01}, sos), 1},
+		{"length past end", jpegOf([]byte{0xFF, 0xE1, 0x00, 0x40, 'E', 'x'}), 1},
+		{"no EXIF", jpegOf(app0, sos), 1},
+		{"little endian", jpegOf(app0, segment(0xE1, exifOrientation(binary.LittleEndian, 6)), sos), 6},
+		{"big endian", jpegOf(segment(0xE1, exifOrientation(binary.BigEndian, 3)), sos), 3},
+		{"out of range value", jpegOf(segment(0xE1, exifOrientation(binary.LittleEndian, 9)), sos), 1},
+		{"EXIF after SOS is ignored", jpegOf(sos, segment(0xE1, exifOrientation(binary.LittleEndian, 6))), 1},
+		{"truncated TIFF", jpegOf(segment(0xE1, []byte("Exif\x00\x00II*\x00"))), 1},
+		{"bad byte order", jpegOf(segment(0xE1, []byte("Exif\x00\x00XX*\x00\x08\x00\x00\x00"))), 1},
+		{"IFD offset past end", jpegOf(segment(0xE1, []byte("Exif\x00\x00II*\x00\xFF\xFF\xFF\xFF"))), 1},
+		{"entry count past end", jpegOf(segment(0xE1, []byte("Exif\x00\x00II*\x00\x08\x00\x00\x00\xFF\xFF"))), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripJPEG(t *testing.T) {
+	exif := segment(0xE1, exifOrientation(binary.LittleEndian, 6))
+	comment := segment(0xFE, []byte("taken at home"))
+	iptc := segment(0xED, []byte("Photoshop 3.0\x00"))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"empty", nil, nil, true},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n"), nil, true},
+		{"only SOI", jpegOf(), nil, true},
+		{"no SOS", jpegOf(app0), nil, true},
+		{"truncated length", jpegOf(app0, []byte{0xFF, 0xE1, 0x00}), nil, true},
+		{"zero length segment", jpegOf([]byte{0xFF, 0xE1, 0x00, 0x00}, sos), nil, true},
+		{"one byte length segment", jpegOf([]byte{0xFF, 0xE1, 0x00, 0x01}, sos), nil, true},
+		{"length past end", jpegOf([]byte{0xFF, 0xE0, 0x00, 0x40, 0x00}), nil, true},
+		{"garbage between segments", jpegOf(app0, []byte{0x00}, sos), nil, true},
+		{"nothing to strip", jpegOf(app0, sos), jpegOf(app0, sos), false},
+		{"metadata removed", jpegOf(app0, exif, comment, iptc, sos), jpegOf(app0, sos), false},
+		{"padding and restart markers kept", jpegOf([]byte{0xFF}, app0, []byte{0xFF, 0xD0}, exif, sos), jpegOf(app0, []byte{0xFF, 0xD0}, sos), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripJPEG(tt.data)
+			if tt.wantErr {
+				if !errors.Is(err, errMalformed) {
+					t.Fatalf("stripJPEG() error = %v, want errMalformed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("stripJPEG() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripJPEG() = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
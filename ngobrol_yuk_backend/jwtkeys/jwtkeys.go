@@ -0,0 +1,242 @@
+// Package jwtkeys signs and verifies access tokens. With a key directory tokens are
+// signed with RS256 or EdDSA and carry a kid header, so other services can verify
+// them from the published JWKS without sharing a secret.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSABits rejects RSA keys too small to be trusted by other services
+const minRSABits = 2048
+
+// SigningKIDFile is an optional file in the key directory naming the key new tokens are signed with.
+// It is read on every load, so switching the signing key only takes a reload, not a restart.
+const SigningKIDFile = "signing_kid"
+
+var (
+	ErrNoKeySet   = errors.New("no JWT key set configured")
+	ErrUnknownKey = errors.New("unknown key ID")
+)
+
+// Key is one entry of the key directory. Keys without a private part are kept
+// only to verify tokens signed before a rotation.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeySet holds every key tokens may be signed with and the one new tokens are signed with
+type KeySet struct {
+	keys    map[string]*Key
+	signing *Key
+	secret  []byte // HS256 secret, only when no key directory is configured
+}
+
+// NewHMAC keeps the legacy shared-secret HS256 signing, the JWKS is empty
+func NewHMAC(secret string) (*KeySet, error) {
+	if secret == "" {
+		return nil, errors.New("JWT secret is empty")
+	}
+	return &KeySet{secret: []byte(secret)}, nil
+}
+
+// LoadDir reads every *.pem file in dir; the file name without extension is the kid.
+// A file may hold an RSA or Ed25519 private key, or only the public key of a retired one.
+// New tokens are signed with the kid in SigningKIDFile, or signingKID when that file is
+// missing, and the key must have a private part.
+func LoadDir(dir, signingKID string) (*KeySet, error) {
+	kid, err := os.ReadFile(filepath.Join(dir, SigningKIDFile))
+	switch {
+	case err == nil:
+		signingKID = strings.TrimSpace(string(kid))
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	if signingKID == "" {
+		return nil, fmt.Errorf("no signing key ID given and no %s file in %s", SigningKIDFile, dir)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .pem keys found in %s", dir)
+	}
+
+	ks := &KeySet{keys: make(map[string]*Key, len(paths))}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		ks.keys[kid] = key
+	}
+
+	signing, ok := ks.keys[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found in %s", signingKID, dir)
+	}
+	if signing.private == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKID)
+	}
+	ks.signing = signing
+
+	return ks, nil
+}
+
+func parseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: kid}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.private = signer
+		parsed = signer.Public()
+	}
+
+	switch pub := parsed.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key must be at least %d bits", minRSABits)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.public = pub
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.public = pub
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", pub)
+	}
+
+	return key, nil
+}
+
+// Sign signs claims with the signing key and names it in the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.secret)
+	}
+
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.private)
+}
+
+// Keyfunc picks the verification key named by the kid header and insists on that key's
+// algorithm, so a token can never choose how it is verified
+func (ks *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	if ks.signing == nil {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("invalid signing method")
+		}
+		return ks.secret, nil
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("invalid signing method")
+	}
+	return key.public, nil
+}
+
+// SigningKID is the kid new tokens are signed with, empty for HS256
+func (ks *KeySet) SigningKID() string {
+	if ks.signing == nil {
+		return ""
+	}
+	return ks.signing.ID
+}
+
+// KeyIDs lists the loaded kids in order, for logging
+func (ks *KeySet) KeyIDs() []string {
+	ids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		ids = append(ids, kid)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+var (
+	mu      sync.RWMutex
+	current *KeySet
+)
+
+// Use makes ks the key set of the process. It must be called at startup, before
+// tokens are issued, and may be called again later to swap in a reloaded directory.
+func Use(ks *KeySet) {
+	mu.Lock()
+	current = ks
+	mu.Unlock()
+}
+
+func active() *KeySet {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Sign signs claims with the key set passed to Use
+func Sign(claims jwt.Claims) (string, error) {
+	ks := active()
+	if ks == nil {
+		return "", ErrNoKeySet
+	}
+	return ks.Sign(claims)
+}
+
+// Keyfunc verifies tokens against the key set passed to Use, pass it to jwt.Parse
+func Keyfunc(t *jwt.Token) (interface{}, error) {
+	ks := active()
+	if ks == nil {
+		return nil, ErrNoKeySet
+	}
+	return ks.Keyfunc(t)
+}
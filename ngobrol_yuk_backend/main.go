@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/Adisonsmn/ngobrolyuk/backplane"
 	"github.com/Adisonsmn/ngobrolyuk/config"
+	"github.com/Adisonsmn/ngobrolyuk/controllers"
+	"github.com/Adisonsmn/ngobrolyuk/denylist"
+	"github.com/Adisonsmn/ngobrolyuk/jwtkeys"
 	"github.com/Adisonsmn/ngobrolyuk/routes"
+	"github.com/Adisonsmn/ngobrolyuk/storage"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -16,8 +22,58 @@ func main() {
 	config.ConnectDB()
 	defer config.DisconnectDB()
 
+	// Keys access tokens are signed and verified with
+	keys, err := loadJWTKeys()
+	if err != nil {
+		log.Fatal("Failed to load JWT keys:", err)
+	}
+	jwtkeys.Use(keys)
+
+	// Revoked tokens are checked from memory, load them before serving requests
+	denylistCtx, stopDenylist := context.WithCancel(context.Background())
+	defer stopDenylist()
+
+	if err := denylist.Start(denylistCtx, config.DB); err != nil {
+		log.Fatal("Failed to load token denylist:", err)
+	}
+
+	// Connect the WebSocket hub to other replicas
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+
+	switch config.GetEnvWithDefault("HUB_BACKPLANE", "memory") {
+	case "mongo":
+		controllers.UseBackplane(hubCtx, backplane.NewMongo(config.DB))
+	case "memory":
+		controllers.UseBackplane(hubCtx, backplane.NewMemory())
+	default:
+		log.Fatal("HUB_BACKPLANE must be either memory or mongo")
+	}
+
+	// Choose where uploaded media is stored
+	switch config.GetEnvWithDefault("MEDIA_STORAGE", "local") {
+	case "gridfs":
+		store, err := storage.NewGridFS(config.DB)
+		if err != nil {
+			log.Fatal("Failed to open GridFS media storage:", err)
+		}
+		controllers.UseMediaStorage(store)
+	case "local":
+		store, err := storage.NewLocal(config.GetEnvWithDefault("MEDIA_DIR", "uploads"))
+		if err != nil {
+			log.Fatal("Failed to open local media storage:", err)
+		}
+		controllers.UseMediaStorage(store)
+	default:
+		log.Fatal("MEDIA_STORAGE must be either local or gridfs")
+	}
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
+		// Bodies over the default limit are streamed rather than rejected, middleware.BodyLimit
+		// keeps every route at the default except media uploads
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			message := "Internal Server Error"
@@ -45,6 +101,21 @@ func main() {
 	// Get port from environment
 	port := config.GetEnvWithDefault("PORT", "8080")
 
+	// SIGHUP reloads the key directory, e.g. to publish the next key, switch to it or drop a retired one
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			keys, err := loadJWTKeys()
+			if err != nil {
+				log.Printf("Keeping current JWT keys, reload failed: %v", err)
+				continue
+			}
+			jwtkeys.Use(keys)
+		}
+	}()
+
 	// Setup graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -61,3 +132,22 @@ func main() {
 		log.Fatal("Server failed to start:", err)
 	}
 }
+
+// loadJWTKeys reads the key files in JWT_KEYS_DIR and signs with the kid in its signing_kid
+// file, or JWT_SIGNING_KID without one.
+// Without a key directory it falls back to HS256 with JWT_SECRET.
+func loadJWTKeys() (*jwtkeys.KeySet, error) {
+	dir := config.GetEnvWithDefault("JWT_KEYS_DIR", "")
+	if dir == "" {
+		log.Printf("JWT_KEYS_DIR not set, signing tokens with HS256 and JWT_SECRET")
+		return jwtkeys.NewHMAC(os.Getenv("JWT_SECRET"))
+	}
+
+	keys, err := jwtkeys.LoadDir(dir, os.Getenv("JWT_SIGNING_KID"))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loaded JWT keys %v, signing with %s", keys.KeyIDs(), keys.SigningKID())
+	return keys, nil
+}
@@ -3,11 +3,13 @@ package middleware
 import (
 	"errors"
 	"fmt"
-	"os"
 	"time"
 
+	"github.com/Adisonsmn/ngobrolyuk/denylist"
+	"github.com/Adisonsmn/ngobrolyuk/jwtkeys"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func Protect(c *fiber.Ctx) error {
@@ -29,13 +31,8 @@ func Protect(c *fiber.Ctx) error {
 	}
 
 	// Parse and validate token
-	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+	// The key named by the kid header also fixes the signing method
+	token, err := jwt.Parse(tokenStr, jwtkeys.Keyfunc)
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -69,6 +66,15 @@ func Protect(c *fiber.Ctx) error {
 		})
 	}
 
+	// Every access token belongs to a session, tokens from before sessions existed are rejected
+	sid, _ := claims["sid"].(string)
+	sessionID, err := primitive.ObjectIDFromHex(sid)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid session in token",
+		})
+	}
+
 	// Check expiration
 	exp, ok := claims["exp"].(float64)
 	if !ok || time.Now().Unix() > int64(exp) {
@@ -77,8 +83,24 @@ func Protect(c *fiber.Ctx) error {
 		})
 	}
 
+	tokenID, _ := claims["jti"].(string)
+	if tokenID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token ID",
+		})
+	}
+
+	// Logged out tokens and revoked sessions are rejected before they expire, from memory only
+	if denylist.Contains(tokenID) || denylist.Contains(sessionID.Hex()) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Token revoked",
+		})
+	}
+
 	// Store user info in context
 	c.Locals("user_id", userID)
+	c.Locals("session_id", sessionID)
+	c.Locals("token_id", tokenID)
 	c.Locals("jwt_exp", exp)
 
 	return c.Next()
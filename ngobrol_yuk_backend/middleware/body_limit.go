@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimit caps request bodies at limit bytes. The server streams bodies over its own
+// limit instead of rejecting them, so this is what bounds them. routeLimits overrides the
+// limit for single routes, keyed by "METHOD /path".
+func BodyLimit(limit int, routeLimits map[string]int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		max := limit
+		if l, ok := routeLimits[c.Method()+" "+strings.TrimSuffix(c.Path(), "/")]; ok {
+			max = l
+		}
+
+		req := c.Request()
+		if req.Header.ContentLength() > max {
+			return bodyTooLarge(c)
+		}
+
+		// Chunked bodies carry no length, read one byte past the limit to detect them
+		if stream := req.BodyStream(); stream != nil {
+			body, err := io.ReadAll(io.LimitReader(stream, int64(max)+1))
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Failed to read request body",
+				})
+			}
+			if len(body) > max {
+				return bodyTooLarge(c)
+			}
+			req.SetBody(body)
+		}
+
+		return c.Next()
+	}
+}
+
+func bodyTooLarge(c *fiber.Ctx) error {
+	// The rest of the body is never read, the connection cannot be reused
+	c.Context().SetConnectionClose()
+	return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+		"error": "Request body too large",
+	})
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ContactStatusPending  = "pending"
+	ContactStatusAccepted = "accepted"
+)
+
+// Contact links two users, it starts pending until AddresseeID accepts the request
+type Contact struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	Pair        string             `bson:"pair" json:"-"` // ContactPair of both users, one document per pair
+	RequesterID string             `bson:"requester_id" json:"requester_id"`
+	AddresseeID string             `bson:"addressee_id" json:"addressee_id"`
+	Status      string             `bson:"status" json:"status"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	AcceptedAt  *time.Time         `bson:"accepted_at,omitempty" json:"accepted_at,omitempty"`
+}
+
+// ContactPair is the same for both orders of the two user IDs
+func ContactPair(userID, otherID string) string {
+	if userID < otherID {
+		return userID + ":" + otherID
+	}
+	return otherID + ":" + userID
+}
+
+// Other returns the user on the other side of the contact
+func (c *Contact) Other(userID string) string {
+	if c.RequesterID == userID {
+		return c.AddresseeID
+	}
+	return c.RequesterID
+}
+
+// StatusFor describes the contact from userID's point of view:
+// "accepted", "outgoing" (waiting for the other user) or "incoming" (waiting for userID)
+func (c *Contact) StatusFor(userID string) string {
+	switch {
+	case c.Status == ContactStatusAccepted:
+		return ContactStatusAccepted
+	case c.RequesterID == userID:
+		return "outgoing"
+	default:
+		return "incoming"
+	}
+}
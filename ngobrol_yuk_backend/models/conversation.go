@@ -0,0 +1,183 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ConversationTypeGroup = "group"
+
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+
+	MaxGroupMembers = 256
+
+	MaxPinnedConversations = 5
+)
+
+type ConversationMember struct {
+	UserID   string    `bson:"user_id" json:"user_id"`
+	Role     string    `bson:"role" json:"role"` // "owner", "admin", "member"
+	JoinedAt time.Time `bson:"joined_at" json:"joined_at"`
+}
+
+type Conversation struct {
+	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Type      string               `bson:"type" json:"type"`
+	Name      string               `bson:"name" json:"name"`
+	OwnerID   string               `bson:"owner_id" json:"owner_id"`
+	Members   []ConversationMember `bson:"members" json:"members"`
+	CreatedAt time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+// Member returns the membership entry of userID, or nil if not a member
+func (c *Conversation) Member(userID string) *ConversationMember {
+	for i := range c.Members {
+		if c.Members[i].UserID == userID {
+			return &c.Members[i]
+		}
+	}
+	return nil
+}
+
+func (c *Conversation) MemberIDs() []string {
+	ids := make([]string, 0, len(c.Members))
+	for _, m := range c.Members {
+		ids = append(ids, m.UserID)
+	}
+	return ids
+}
+
+// CanManage reports whether the member may rename the group and add or remove members
+func (m *ConversationMember) CanManage() bool {
+	return m.Role == RoleOwner || m.Role == RoleAdmin
+}
+
+// ConversationSettings are one user's preferences for a direct chat with PeerID or a group
+// chat with ConversationID, exactly one of the two is set
+type ConversationSettings struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"-"`
+	UserID          string              `bson:"user_id" json:"-"`
+	PeerID          string              `bson:"peer_id,omitempty" json:"peer_id,omitempty"`
+	ConversationID  *primitive.ObjectID `bson:"conversation_id,omitempty" json:"conversation_id,omitempty"`
+	Archived        bool                `bson:"archived" json:"archived"`
+	Pinned          bool                `bson:"pinned" json:"pinned"`
+	PinnedAt        *time.Time          `bson:"pinned_at,omitempty" json:"pinned_at,omitempty"`
+	Muted           bool                `bson:"muted" json:"muted"`
+	MutedUntil      *time.Time          `bson:"muted_until,omitempty" json:"muted_until,omitempty"` // Nil mutes until turned off
+	RequestAccepted bool                `bson:"request_accepted" json:"request_accepted"`           // Moves a chat with a non-contact out of message requests
+	UpdatedAt       time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// IsMuted reports whether the chat is muted at the given time
+func (s *ConversationSettings) IsMuted(now time.Time) bool {
+	return s.Muted && (s.MutedUntil == nil || s.MutedUntil.After(now))
+}
+
+type CreateGroupRequest struct {
+	Name      string   `json:"name" validate:"required,max=100"`
+	MemberIDs []string `json:"member_ids"`
+}
+
+type RenameGroupRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type AddMembersRequest struct {
+	UserIDs []string `json:"user_ids" validate:"required"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" validate:"oneof=admin member"`
+}
+
+// UpdateConversationSettingsRequest only changes the fields that are present
+type UpdateConversationSettingsRequest struct {
+	Archived   *bool      `json:"archived"`
+	Pinned     *bool      `json:"pinned"`
+	Muted      *bool      `json:"muted"`
+	MutedUntil *time.Time `json:"muted_until"` // Optional end of a mute, implies muted
+}
+
+func (r *CreateGroupRequest) Validate() []string {
+	var errors []string
+
+	r.Name = strings.TrimSpace(r.Name)
+	errors = append(errors, validateGroupName(r.Name)...)
+
+	if len(r.MemberIDs) >= MaxGroupMembers {
+		errors = append(errors, "Too many members (max 256 per group)")
+	}
+
+	return errors
+}
+
+func (r *RenameGroupRequest) Validate() []string {
+	r.Name = strings.TrimSpace(r.Name)
+	return validateGroupName(r.Name)
+}
+
+func (r *AddMembersRequest) Validate() []string {
+	var errors []string
+
+	if len(r.UserIDs) == 0 {
+		errors = append(errors, "At least one user ID is required")
+	}
+
+	if len(r.UserIDs) >= MaxGroupMembers {
+		errors = append(errors, "Too many members (max 256 per group)")
+	}
+
+	return errors
+}
+
+func (r *UpdateMemberRoleRequest) Validate() []string {
+	var errors []string
+
+	if r.Role != RoleAdmin && r.Role != RoleMember {
+		errors = append(errors, "Role must be either admin or member")
+	}
+
+	return errors
+}
+
+func (r *UpdateConversationSettingsRequest) Validate() []string {
+	var errors []string
+
+	if r.Archived == nil && r.Pinned == nil && r.Muted == nil && r.MutedUntil == nil {
+		errors = append(errors, "At least one setting is required")
+	}
+
+	if r.Archived != nil && r.Pinned != nil && *r.Archived && *r.Pinned {
+		errors = append(errors, "An archived conversation cannot be pinned")
+	}
+
+	if r.MutedUntil != nil {
+		if r.Muted != nil && !*r.Muted {
+			errors = append(errors, "muted_until cannot be set when unmuting")
+		} else if !r.MutedUntil.After(time.Now()) {
+			errors = append(errors, "muted_until must be in the future")
+		}
+	}
+
+	return errors
+}
+
+func validateGroupName(name string) []string {
+	var errors []string
+
+	if name == "" {
+		errors = append(errors, "Group name is required")
+	}
+
+	if len(name) > 100 {
+		errors = append(errors, "Group name too long (max 100 characters)")
+	}
+
+	return errors
+}
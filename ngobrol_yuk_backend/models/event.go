@@ -0,0 +1,277 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EventProtocolVersion is the envelope version spoken over /ws
+const EventProtocolVersion = 1
+
+// Client -> server event types
+const (
+	EventSendMessage      = "send_message"
+	EventResync           = "resync"
+	EventMessageDelivered = "message_delivered"
+	EventMessageRead      = "message_read"
+	EventEditMessage      = "edit_message"
+	EventDeleteMessage    = "delete_message"
+	EventAddReaction      = "add_reaction"
+	EventRemoveReaction   = "remove_reaction"
+)
+
+// Bidirectional event types: sent by the typing client and relayed to the other participants
+const (
+	EventTypingStart = "typing_start"
+	EventTypingStop  = "typing_stop"
+)
+
+// Server -> client event types
+const (
+	EventMessage = "message"
+	EventError   = "error"
+	EventAck     = "ack"
+	EventNack    = "nack"
+
+	EventResyncBatch    = "resync_batch"
+	EventResyncComplete = "resync_complete"
+	EventReceipt        = "receipt"
+
+	EventPresenceChanged = "presence_changed"
+	EventMessageEdited   = "message_edited"
+	EventMessageDeleted  = "message_deleted"
+	EventReactionChanged = "reaction_changed"
+	EventContactChanged  = "contact_changed"
+	EventSessionRevoked  = "session_revoked"
+	EventMessageRequest  = "message_request" // Replaces message for the receiver while the chat is a message request
+)
+
+// Error codes carried by EventError and EventNack
+const (
+	ErrCodeInvalidEvent       = "invalid_event"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeValidationFailed   = "validation_failed"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeInternal           = "internal_error"
+	ErrCodeDeliveryFailed     = "delivery_failed"
+	ErrCodeEditWindowExpired  = "edit_window_expired"
+	ErrCodeConflict           = "conflict"
+)
+
+// Event is the envelope for every WebSocket frame in both directions.
+// ID is chosen by the client and echoed back on replies to that event.
+type Event struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type ErrorPayload struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// AckPayload confirms that a send_message was persisted
+type AckPayload struct {
+	ClientMsgID string             `json:"client_msg_id,omitempty"`
+	MessageID   primitive.ObjectID `json:"message_id"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Duplicate   bool               `json:"duplicate,omitempty"` // Already stored by an earlier attempt
+	Warning     string             `json:"warning,omitempty"`   // delivery_failed: stored, but other devices only get it on resync
+}
+
+// NackPayload reports why a send_message was not accepted
+type NackPayload struct {
+	ClientMsgID string   `json:"client_msg_id,omitempty"`
+	Code        string   `json:"code"`
+	Reason      string   `json:"reason"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+// ResyncRequest asks for every message after the client's last-seen point.
+// LastMessageID takes precedence over Since when both are set.
+type ResyncRequest struct {
+	LastMessageID string     `json:"last_message_id"`
+	Since         *time.Time `json:"since"`
+}
+
+type ResyncBatchPayload struct {
+	Messages []Message `json:"messages"`
+}
+
+type ResyncCompletePayload struct {
+	Count         int                 `json:"count"`
+	LastMessageID *primitive.ObjectID `json:"last_message_id,omitempty"`
+	HasMore       bool                `json:"has_more"` // Resync again from LastMessageID to continue
+}
+
+func (r *ResyncRequest) Validate() []string {
+	var errors []string
+
+	if r.LastMessageID == "" && r.Since == nil {
+		errors = append(errors, "last_message_id or since is required")
+	}
+
+	if r.LastMessageID != "" && !primitive.IsValidObjectID(r.LastMessageID) {
+		errors = append(errors, "Invalid last_message_id")
+	}
+
+	return errors
+}
+
+// TypingRequest targets either a direct chat partner or a group
+type TypingRequest struct {
+	ReceiverID     string `json:"receiver_id"`
+	ConversationID string `json:"conversation_id"`
+}
+
+type TypingPayload struct {
+	UserID         string `json:"user_id"`
+	ReceiverID     string `json:"receiver_id,omitempty"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	ExpiresInMs    int64  `json:"expires_in_ms,omitempty"` // Only on typing_start
+}
+
+func (r *TypingRequest) Validate() []string {
+	var errors []string
+
+	if (r.ReceiverID == "") == (r.ConversationID == "") {
+		errors = append(errors, "Exactly one of receiver_id or conversation_id is required")
+	}
+
+	return errors
+}
+
+// DeliveredRequest is sent by a device once it has received messages
+type DeliveredRequest struct {
+	MessageIDs []string `json:"message_ids"`
+}
+
+// ReadRequest marks everything in a chat as read by the caller.
+// UserID is the other participant of a direct chat.
+type ReadRequest struct {
+	UserID         string `json:"user_id"`
+	ConversationID string `json:"conversation_id"`
+}
+
+// ReceiptPayload tells senders (and the reader's other devices) about a state change
+type ReceiptPayload struct {
+	Status         string               `json:"status"` // "delivered" or "read"
+	UserID         string               `json:"user_id"`
+	ConversationID string               `json:"conversation_id,omitempty"`
+	MessageIDs     []primitive.ObjectID `json:"message_ids"`
+	At             time.Time            `json:"at"`
+}
+
+func (r *DeliveredRequest) Validate() []string {
+	var errors []string
+
+	if len(r.MessageIDs) == 0 {
+		errors = append(errors, "At least one message ID is required")
+	}
+
+	if len(r.MessageIDs) > 500 {
+		errors = append(errors, "Too many message IDs (max 500)")
+	}
+
+	for _, id := range r.MessageIDs {
+		if !primitive.IsValidObjectID(id) {
+			errors = append(errors, "Invalid message ID: "+id)
+			break
+		}
+	}
+
+	return errors
+}
+
+func (r *ReadRequest) Validate() []string {
+	var errors []string
+
+	if (r.UserID == "") == (r.ConversationID == "") {
+		errors = append(errors, "Exactly one of user_id or conversation_id is required")
+	}
+
+	return errors
+}
+
+type PresencePayload struct {
+	UserID   string    `json:"user_id"`
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// MessageEditedPayload is pushed to every participant after a message is edited
+type MessageEditedPayload struct {
+	MessageID      primitive.ObjectID  `json:"message_id"`
+	ConversationID *primitive.ObjectID `json:"conversation_id,omitempty"`
+	SenderID       string              `json:"sender_id"`
+	ReceiverID     string              `json:"receiver_id,omitempty"`
+	Content        string              `json:"content"`
+	EditedAt       time.Time           `json:"edited_at"`
+}
+
+// MessageDeletedPayload goes to every participant for "everyone", and only to the caller's devices for "me"
+type MessageDeletedPayload struct {
+	MessageID      primitive.ObjectID  `json:"message_id"`
+	ConversationID *primitive.ObjectID `json:"conversation_id,omitempty"`
+	SenderID       string              `json:"sender_id"`
+	ReceiverID     string              `json:"receiver_id,omitempty"`
+	Mode           string              `json:"mode"`
+	DeletedAt      time.Time           `json:"deleted_at"`
+}
+
+// ReactionChangedPayload carries the change and the resulting aggregated reactions of the message
+type ReactionChangedPayload struct {
+	MessageID      primitive.ObjectID  `json:"message_id"`
+	ConversationID *primitive.ObjectID `json:"conversation_id,omitempty"`
+	UserID         string              `json:"user_id"`
+	Emoji          string              `json:"emoji"`
+	Added          bool                `json:"added"` // false when the reaction was removed
+	Reactions      []MessageReaction   `json:"reactions"`
+}
+
+// ContactChangedPayload tells a user that another user requested, accepted or removed a contact.
+// Status is seen from the receiving user: "incoming", "accepted" or "removed".
+type ContactChangedPayload struct {
+	UserID string `json:"user_id"`
+	Status string `json:"status"`
+}
+
+// SessionRevokedPayload is the last event a device gets before the server closes its
+// WebSocket because the session it logged in with was revoked
+type SessionRevokedPayload struct {
+	SessionID primitive.ObjectID `json:"session_id"`
+	Reason    string             `json:"reason"`
+}
+
+func NewEvent(eventType, id string, payload interface{}) (Event, error) {
+	evt := Event{
+		Version: EventProtocolVersion,
+		Type:    eventType,
+		ID:      id,
+	}
+
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return Event{}, err
+		}
+		evt.Payload = raw
+	}
+
+	return evt, nil
+}
+
+// DecodePayload unmarshals the payload into v
+func (e *Event) DecodePayload(v interface{}) error {
+	if len(e.Payload) == 0 {
+		return json.Unmarshal([]byte("{}"), v)
+	}
+	return json.Unmarshal(e.Payload, v)
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Media is an uploaded blob; the bytes live in the configured storage under ID.Hex()
+type Media struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	OwnerID     string             `bson:"owner_id" json:"owner_id"`
+	FileName    string             `bson:"file_name" json:"file_name"`
+	ContentType string             `bson:"content_type" json:"content_type"`
+	Size        int64              `bson:"size" json:"size"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+
+	// Filled in for images when they are uploaded
+	ImageInfo `bson:",inline"`
+}
+
+// ImageInfo describes an uploaded image so clients can lay it out before downloading it
+type ImageInfo struct {
+	Width     int             `bson:"width,omitempty" json:"width,omitempty"`
+	Height    int             `bson:"height,omitempty" json:"height,omitempty"`
+	Blurhash  string          `bson:"blurhash,omitempty" json:"blurhash,omitempty"` // Placeholder shown while loading
+	Thumbnail *MediaThumbnail `bson:"thumbnail,omitempty" json:"thumbnail,omitempty"`
+}
+
+// MediaThumbnail is served by GET /api/v1/media/:id/thumbnail
+type MediaThumbnail struct {
+	ContentType string `bson:"content_type" json:"content_type"`
+	Width       int    `bson:"width" json:"width"`
+	Height      int    `bson:"height" json:"height"`
+	Size        int64  `bson:"size" json:"size"`
+}
+
+// Attachment is the copy of a media's metadata embedded in the message referencing it
+type Attachment struct {
+	ID          primitive.ObjectID `bson:"id" json:"id"`
+	FileName    string             `bson:"file_name" json:"file_name"`
+	ContentType string             `bson:"content_type" json:"content_type"`
+	Size        int64              `bson:"size" json:"size"`
+
+	ImageInfo `bson:",inline"`
+}
+
+func (m *Media) IsImage() bool {
+	return strings.HasPrefix(m.ContentType, "image/")
+}
+
+func (m *Media) Attachment() *Attachment {
+	return &Attachment{
+		ID:          m.ID,
+		FileName:    m.FileName,
+		ContentType: m.ContentType,
+		Size:        m.Size,
+		ImageInfo:   m.ImageInfo,
+	}
+}
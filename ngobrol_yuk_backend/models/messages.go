@@ -2,31 +2,195 @@ package models
 
 import (
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	MessageTypeText  = "text"
+	MessageTypeImage = "image"
+	MessageTypeFile  = "file"
+)
+
+const (
+	DeleteForMe       = "me"
+	DeleteForEveryone = "everyone"
+)
+
+const (
+	MessageStatusSent      = "sent"
+	MessageStatusDelivered = "delivered"
+	MessageStatusRead      = "read"
+)
+
+// MessageReceipt records when a group member received or read a message
+type MessageReceipt struct {
+	UserID string    `bson:"user_id" json:"user_id"`
+	At     time.Time `bson:"at" json:"at"`
+}
+
 type Message struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	SenderID   string             `bson:"sender_id" json:"sender_id"`
-	ReceiverID string             `bson:"receiver_id" json:"receiver_id"`
-	Content    string             `bson:"content" json:"content"`
-	Type       string             `bson:"type" json:"type"` // "text", "image", etc
-	Read       bool               `bson:"read" json:"read"`
-	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ConversationID *primitive.ObjectID `bson:"conversation_id,omitempty" json:"conversation_id,omitempty"` // Set for group messages
+	ClientMsgID    string              `bson:"client_msg_id,omitempty" json:"client_msg_id,omitempty"`     // Sender-generated, used for idempotent retries
+	Undelivered    bool                `bson:"undelivered,omitempty" json:"-"`                             // The hub refused it, a retry with the same ClientMsgID delivers it
+	SenderID       string              `bson:"sender_id" json:"sender_id"`
+	ReceiverID     string              `bson:"receiver_id,omitempty" json:"receiver_id,omitempty"`
+	Content        string              `bson:"content" json:"content"`
+	Type           string              `bson:"type" json:"type"` // "text", "image", "file"
+	Read           bool                `bson:"read" json:"read"` // Kept in sync with Status for older clients
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+
+	// Uploaded media of image and file messages, Content is then an optional caption
+	Attachment *Attachment `bson:"attachment,omitempty" json:"attachment,omitempty"`
+
+	// Quoted message, ReplyPreview is filled in when messages are read and never stored
+	ReplyTo      *primitive.ObjectID `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+	ReplyPreview *MessagePreview     `bson:"-" json:"reply_preview,omitempty"`
+
+	// Delivery state of direct messages
+	Status      string     `bson:"status,omitempty" json:"status,omitempty"` // "sent", "delivered", "read"
+	DeliveredAt *time.Time `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `bson:"read_at,omitempty" json:"read_at,omitempty"`
+
+	// Delivery state of group messages, one receipt per member
+	DeliveredTo []MessageReceipt `bson:"delivered_to,omitempty" json:"delivered_to,omitempty"`
+	ReadBy      []MessageReceipt `bson:"read_by,omitempty" json:"read_by,omitempty"`
+
+	// Edit history, Revisions holds every earlier content oldest first
+	EditedAt  *time.Time        `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	Revisions []MessageRevision `bson:"revisions,omitempty" json:"revisions,omitempty"`
+
+	// Reactions are aggregated per emoji
+	Reactions []MessageReaction `bson:"reactions,omitempty" json:"reactions,omitempty"`
+
+	// Deletion: DeletedAt marks a tombstone deleted for everyone, HiddenFor lists users who deleted it for themselves
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	HiddenFor []string   `bson:"hidden_for,omitempty" json:"-"`
+
+	// Last edit, deletion for everyone or reaction change, resync replays messages changed after its cursor
+	UpdatedAt *time.Time `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// messagePreviewLength is the number of characters a quoted message is cut down to
+const messagePreviewLength = 100
+
+// MessagePreview is the compact form of a quoted message shown above a reply
+type MessagePreview struct {
+	ID        primitive.ObjectID `json:"id"`
+	SenderID  string             `json:"sender_id"`
+	Content   string             `json:"content"`
+	Type      string             `json:"type"`
+	Deleted   bool               `json:"deleted,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+func (m *Message) Preview() *MessagePreview {
+	content := []rune(m.Content)
+	if len(content) > messagePreviewLength {
+		content = append(content[:messagePreviewLength], '…')
+	}
+
+	return &MessagePreview{
+		ID:        m.ID,
+		SenderID:  m.SenderID,
+		Content:   string(content),
+		Type:      m.Type,
+		Deleted:   m.DeletedAt != nil,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+// MessageReaction lists every user who reacted to a message with Emoji
+type MessageReaction struct {
+	Emoji   string   `bson:"emoji" json:"emoji"`
+	UserIDs []string `bson:"user_ids" json:"user_ids"`
+}
+
+// MessageRevision is a previous content of an edited message and when it was written
+type MessageRevision struct {
+	Content   string    `bson:"content" json:"content"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 }
 
 type SendMessageRequest struct {
-	ReceiverID string `json:"receiver_id" validate:"required"`
-	Content    string `json:"content" validate:"required,max=1000"`
-	Type       string `json:"type" validate:"oneof=text image"`
+	ClientMsgID    string `json:"client_msg_id" validate:"max=64"`
+	ReceiverID     string `json:"receiver_id"`
+	ConversationID string `json:"conversation_id"`
+	Content        string `json:"content" validate:"required,max=1000"`
+	Type           string `json:"type" validate:"oneof=text image file"`
+	MediaID        string `json:"media_id"` // Returned by POST /api/v1/media, required for file messages
+	ReplyTo        string `json:"reply_to"` // Optional ID of a message in the same conversation
 }
 
 func (r *SendMessageRequest) Validate() []string {
 	var errors []string
 
-	if r.ReceiverID == "" {
-		errors = append(errors, "Receiver ID is required")
+	if r.ReceiverID == "" && r.ConversationID == "" {
+		errors = append(errors, "Receiver ID or conversation ID is required")
+	}
+
+	if r.ReceiverID != "" && r.ConversationID != "" {
+		errors = append(errors, "Only one of receiver ID or conversation ID may be set")
+	}
+
+	if r.Type == "" {
+		r.Type = MessageTypeText
+	}
+
+	switch r.Type {
+	case MessageTypeText:
+		if r.Content == "" {
+			errors = append(errors, "Message content is required")
+		}
+		if r.MediaID != "" {
+			errors = append(errors, "Media ID is only allowed on image and file messages")
+		}
+	case MessageTypeImage:
+		// Older clients send the image URL as content instead of uploading it
+		if r.Content == "" && r.MediaID == "" {
+			errors = append(errors, "Media ID is required for image messages")
+		}
+	case MessageTypeFile:
+		if r.MediaID == "" {
+			errors = append(errors, "Media ID is required for file messages")
+		}
+	default:
+		errors = append(errors, "Type must be text, image or file")
+	}
+
+	if r.MediaID != "" && !primitive.IsValidObjectID(r.MediaID) {
+		errors = append(errors, "Invalid media ID")
+	}
+
+	if len(r.Content) > 1000 {
+		errors = append(errors, "Message too long (max 1000 characters)")
+	}
+
+	if len(r.ClientMsgID) > 64 {
+		errors = append(errors, "Client message ID too long (max 64 characters)")
+	}
+
+	if r.ReplyTo != "" && !primitive.IsValidObjectID(r.ReplyTo) {
+		errors = append(errors, "Invalid reply_to message ID")
+	}
+
+	return errors
+}
+
+// EditMessageRequest replaces the content of a message; MessageID comes from the URL on HTTP
+type EditMessageRequest struct {
+	MessageID string `json:"message_id"`
+	Content   string `json:"content" validate:"required,max=1000"`
+}
+
+func (r *EditMessageRequest) Validate() []string {
+	var errors []string
+
+	if !primitive.IsValidObjectID(r.MessageID) {
+		errors = append(errors, "Invalid message ID")
 	}
 
 	if r.Content == "" {
@@ -37,9 +201,67 @@ func (r *SendMessageRequest) Validate() []string {
 		errors = append(errors, "Message too long (max 1000 characters)")
 	}
 
-	if r.Type == "" {
-		r.Type = "text"
+	return errors
+}
+
+// DeleteMessageRequest hides a message for the caller or, for its sender, deletes it for everyone
+type DeleteMessageRequest struct {
+	MessageID string `json:"message_id"`
+	Mode      string `json:"mode" validate:"oneof=me everyone"`
+}
+
+func (r *DeleteMessageRequest) Validate() []string {
+	var errors []string
+
+	if !primitive.IsValidObjectID(r.MessageID) {
+		errors = append(errors, "Invalid message ID")
+	}
+
+	if r.Mode == "" {
+		r.Mode = DeleteForMe
+	}
+
+	if r.Mode != DeleteForMe && r.Mode != DeleteForEveryone {
+		errors = append(errors, "Mode must be either me or everyone")
+	}
+
+	return errors
+}
+
+// ReactionRequest adds or removes the caller's Emoji reaction on a message
+type ReactionRequest struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji" validate:"required,max=32"`
+}
+
+func (r *ReactionRequest) Validate() []string {
+	var errors []string
+
+	if !primitive.IsValidObjectID(r.MessageID) {
+		errors = append(errors, "Invalid message ID")
+	}
+
+	if r.Emoji == "" {
+		errors = append(errors, "Emoji is required")
+	} else if len(r.Emoji) > 32 || utf8.RuneCountInString(r.Emoji) > 10 {
+		errors = append(errors, "Emoji too long")
+	} else if !isEmoji(r.Emoji) {
+		errors = append(errors, "Reaction must be an emoji")
 	}
 
 	return errors
 }
+
+// isEmoji rejects plain text; digits, # and * are allowed for keycap sequences
+func isEmoji(s string) bool {
+	hasSymbol := false
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.IsLetter(r) || !utf8.ValidRune(r) {
+			return false
+		}
+		if r > unicode.MaxASCII {
+			hasSymbol = true
+		}
+	}
+	return hasSymbol
+}
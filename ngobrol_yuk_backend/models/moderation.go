@@ -0,0 +1,79 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ReportReasonSpam          = "spam"
+	ReportReasonHarassment    = "harassment"
+	ReportReasonInappropriate = "inappropriate"
+	ReportReasonImpersonation = "impersonation"
+	ReportReasonOther         = "other"
+
+	ReportStatusOpen = "open"
+
+	MaxReportMessages = 20
+)
+
+// Block stops BlockedID from messaging BlockerID and hides both users from each other
+type Block struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	BlockerID string             `bson:"blocker_id" json:"-"`
+	BlockedID string             `bson:"blocked_id" json:"user_id"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Report is reviewed by moderators, Evidence is a snapshot so later edits or deletes do not change it
+type Report struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ReporterID string             `bson:"reporter_id" json:"reporter_id"`
+	ReportedID string             `bson:"reported_id" json:"reported_id"`
+	Reason     string             `bson:"reason" json:"reason"`
+	Details    string             `bson:"details,omitempty" json:"details,omitempty"`
+	Evidence   []Message          `bson:"evidence,omitempty" json:"evidence,omitempty"`
+	Status     string             `bson:"status" json:"status"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type ReportUserRequest struct {
+	Reason     string   `json:"reason" validate:"required"`
+	Details    string   `json:"details" validate:"max=1000"`
+	MessageIDs []string `json:"message_ids"`
+	Block      bool     `json:"block"` // Also block the user
+}
+
+func (r *ReportUserRequest) Validate() []string {
+	var errors []string
+
+	switch r.Reason {
+	case ReportReasonSpam, ReportReasonHarassment, ReportReasonInappropriate, ReportReasonImpersonation, ReportReasonOther:
+	default:
+		errors = append(errors, "Reason must be spam, harassment, inappropriate, impersonation or other")
+	}
+
+	r.Details = strings.TrimSpace(r.Details)
+	if len(r.Details) > 1000 {
+		errors = append(errors, "Details too long (max 1000 characters)")
+	}
+
+	if r.Reason == ReportReasonOther && r.Details == "" {
+		errors = append(errors, "Details are required when the reason is other")
+	}
+
+	if len(r.MessageIDs) > MaxReportMessages {
+		errors = append(errors, "Too many messages (max 20 per report)")
+	}
+
+	for _, id := range r.MessageIDs {
+		if !primitive.IsValidObjectID(id) {
+			errors = append(errors, "Invalid message ID: "+id)
+			break
+		}
+	}
+
+	return errors
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SessionRevokedLogout = "logout"
+	SessionRevokedReuse  = "refresh_token_reuse"
+	SessionRevokedByUser = "revoked_by_user"
+)
+
+// Session is one login of a user, i.e. one refresh token family. Only hashes of the
+// refresh tokens are stored; TokenHash is the current one, PreviousHashes the rotated ones.
+type Session struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         string             `bson:"user_id" json:"-"`
+	TokenHash      string             `bson:"token_hash" json:"-"`
+	PreviousHashes []string           `bson:"previous_hashes,omitempty" json:"-"`
+	UserAgent      string             `bson:"user_agent" json:"user_agent"`
+	IP             string             `bson:"ip" json:"ip"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt     time.Time          `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt      time.Time          `bson:"expires_at" json:"expires_at"`
+	RevokedAt      *time.Time         `bson:"revoked_at,omitempty" json:"-"`
+	RevokeReason   string             `bson:"revoke_reason,omitempty" json:"-"`
+}
+
+// RefreshRequest is only needed by clients that do not keep the refresh_token cookie
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
@@ -7,15 +7,16 @@ import (
 )
 
 type User struct {
-	ID        string    `bson:"_id,omitempty" json:"id"`
-	Username  string    `bson:"username" json:"username"`
-	Email     string    `bson:"email" json:"email"`
-	Password  string    `bson:"password" json:"-"` // Hide password in JSON
-	Bio       string    `bson:"bio" json:"bio"`
-	Avatar    string    `bson:"avatar" json:"avatar"`
-	Online    bool      `bson:"online" json:"online"`
-	LastSeen  time.Time `bson:"last_seen" json:"last_seen"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	ID         string    `bson:"_id,omitempty" json:"id"`
+	Username   string    `bson:"username" json:"username"`
+	Email      string    `bson:"email" json:"email"`
+	Password   string    `bson:"password" json:"-"` // Hide password in JSON
+	Bio        string    `bson:"bio" json:"bio"`
+	Avatar     string    `bson:"avatar" json:"avatar"`
+	Online     bool      `bson:"online" json:"online"`
+	LastSeen   time.Time `bson:"last_seen" json:"last_seen"`
+	LastActive time.Time `bson:"last_active" json:"-"` // Heartbeat while connected
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
 }
 
 type RegisterRequest struct {
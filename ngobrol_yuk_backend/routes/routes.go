@@ -11,6 +11,7 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/websocket/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func SetupRoutes(app *fiber.App) {
@@ -19,6 +20,11 @@ func SetupRoutes(app *fiber.App) {
 		EnableStackTrace: true,
 	}))
 
+	app.Use(middleware.BodyLimit(fiber.DefaultBodyLimit, map[string]int{
+		// Leave room for the multipart envelope around the largest upload
+		fiber.MethodPost + " /api/v1/media": int(controllers.MediaMaxSize()) + 1<<20,
+	}))
+
 	app.Use(logger.New(logger.Config{
 		Format: "[${time}] ${status} - ${method} ${path} - ${latency}\n",
 	}))
@@ -45,6 +51,9 @@ func SetupRoutes(app *fiber.App) {
 		},
 	})
 
+	// Public keys for services that verify access tokens
+	app.Get("/.well-known/jwks.json", controllers.GetJWKS)
+
 	// API routes
 	api := app.Group("/api/v1")
 
@@ -56,6 +65,10 @@ func SetupRoutes(app *fiber.App) {
 		})
 	})
 
+	// Refresh runs on every access token expiry and guesses are useless against a random
+	// refresh token, so it is registered before the auth rate limiter and skips it
+	api.Post("/auth/refresh", controllers.RefreshToken)
+
 	// Public routes (with rate limiting)
 	auth := api.Group("/auth")
 	auth.Use(authLimiter)
@@ -67,22 +80,60 @@ func SetupRoutes(app *fiber.App) {
 
 	// Auth protected routes
 	protected.Post("/auth/logout", controllers.Logout)
-	protected.Post("/auth/refresh", controllers.RefreshToken)
+	protected.Get("/auth/sessions", controllers.ListSessions)         // Logged-in devices
+	protected.Delete("/auth/sessions/:id", controllers.RevokeSession) // Log a device out
 
 	// User routes
 	users := protected.Group("/users")
-	users.Get("/", controllers.ListUsers)            // List users with filters
-	users.Get("/online", controllers.GetOnlineUsers) // Get online users
-	users.Get("/profile", controllers.GetProfile)    // Get own profile
-	users.Put("/profile", controllers.UpdateProfile) // Update own profile
-	users.Get("/:id", controllers.GetUserProfile)    // Get specific user profile
+	users.Get("/", controllers.ListUsers)               // List users with filters
+	users.Get("/online", controllers.GetOnlineUsers)    // Get online users
+	users.Get("/profile", controllers.GetProfile)       // Get own profile
+	users.Put("/profile", controllers.UpdateProfile)    // Update own profile
+	users.Get("/blocked", controllers.ListBlockedUsers) // Users blocked by me
+	users.Get("/:id", controllers.GetUserProfile)       // Get specific user profile
+	users.Post("/:id/block", controllers.BlockUser)     // Block user
+	users.Delete("/:id/block", controllers.UnblockUser) // Unblock user
+	users.Post("/:id/report", controllers.ReportUser)   // Report user to moderators
+
+	// Contact routes
+	contacts := protected.Group("/contacts")
+	contacts.Get("/", controllers.ListContacts)                  // Contacts, or requests with ?status=incoming|outgoing
+	contacts.Post("/:user_id", controllers.AddContact)           // Send or accept a contact request
+	contacts.Post("/:user_id/accept", controllers.AcceptContact) // Accept an incoming request
+	contacts.Delete("/:user_id", controllers.RemoveContact)      // Remove, cancel or decline
 
 	// Chat routes
 	chat := protected.Group("/chat")
-	chat.Get("/messages", controllers.GetMessages)           // Get messages with user
-	chat.Get("/conversations", controllers.GetConversations) // Get all conversations
-	chat.Put("/read/:user_id", controllers.MarkMessagesRead) // Mark messages as read
-	chat.Get("/unread", controllers.GetUnreadCount)          // Get unread count
+	chat.Get("/messages", controllers.GetMessages)                                       // Get messages with user
+	chat.Get("/conversations", controllers.GetConversations)                             // Get conversations page
+	chat.Put("/conversations/:user_id/settings", controllers.UpdateConversationSettings) // Pin, mute or archive a chat
+	chat.Post("/requests/:user_id/accept", controllers.AcceptMessageRequest)             // Move a message request to the inbox
+	chat.Post("/requests/:user_id/decline", controllers.DeclineMessageRequest)           // Delete a message request
+	chat.Put("/read/:user_id", controllers.MarkMessagesRead)                             // Mark messages as read
+	chat.Get("/unread", controllers.GetUnreadCount)                                      // Get unread count
+	chat.Get("/search", controllers.SearchMessages)                                      // Full-text search in own conversations
+	chat.Put("/messages/:id", controllers.EditMessage)                                   // Edit own message
+	chat.Delete("/messages/:id", controllers.DeleteMessage)                              // Delete for me or for everyone
+	chat.Post("/messages/:id/reactions", controllers.AddReaction)                        // React with an emoji
+	chat.Delete("/messages/:id/reactions", controllers.RemoveReaction)                   // Remove own reaction (?emoji=)
+
+	// Group chat routes
+	groups := chat.Group("/groups")
+	groups.Post("/", controllers.CreateGroup)                                   // Create group
+	groups.Get("/", controllers.ListGroups)                                     // List own groups
+	groups.Get("/:id", controllers.GetGroup)                                    // Get group details
+	groups.Put("/:id", controllers.RenameGroup)                                 // Rename group
+	groups.Post("/:id/members", controllers.AddGroupMembers)                    // Add members
+	groups.Delete("/:id/members/:user_id", controllers.RemoveGroupMember)       // Remove member or leave
+	groups.Put("/:id/members/:user_id/role", controllers.UpdateGroupMemberRole) // Promote or demote member
+	groups.Put("/:id/read", controllers.MarkGroupMessagesRead)                  // Mark group messages as read
+	groups.Put("/:id/settings", controllers.UpdateGroupSettings)                // Pin, mute or archive a group
+
+	// Media routes
+	media := protected.Group("/media")
+	media.Post("/", controllers.UploadMedia)                   // Upload file (multipart field "file")
+	media.Get("/:id", controllers.GetMedia)                    // Download file, conversation members only
+	media.Get("/:id/thumbnail", controllers.GetMediaThumbnail) // Download image thumbnail
 
 	// WebSocket route (token in query param)
 	// Apply Protect middleware to /ws
@@ -92,13 +143,15 @@ func SetupRoutes(app *fiber.App) {
 	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
 		// Now we can safely access user_id
 		userID, ok := c.Locals("user_id").(string)
-		if !ok {
+		sessionID, hasSession := c.Locals("session_id").(primitive.ObjectID)
+		tokenID, hasToken := c.Locals("token_id").(string)
+		if !ok || !hasSession || !hasToken {
 			c.Close()
 			return
 		}
 
-		// Pass userID to your controller
-		controllers.WebSocketChatWithAuth(c, userID)
+		// Pass userID and its session to your controller
+		controllers.WebSocketChatWithAuth(c, userID, sessionID, tokenID)
 	}))
 
 	// 404 handler
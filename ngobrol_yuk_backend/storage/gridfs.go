@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GridFSBucket is the bucket name, files end up in media_blobs.files and media_blobs.chunks
+const GridFSBucket = "media_blobs"
+
+// GridFS stores blobs in MongoDB so every backend replica sees the same files
+type GridFS struct {
+	bucket *gridfs.Bucket
+}
+
+func NewGridFS(db *mongo.Database) (*GridFS, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(GridFSBucket))
+	if err != nil {
+		return nil, err
+	}
+	return &GridFS{bucket: bucket}, nil
+}
+
+// GridFS needs a unique file ID, the key itself is used so lookups need no extra index
+func (g *GridFS) Save(ctx context.Context, key string, r io.Reader) error {
+	stream, err := g.bucket.OpenUploadStreamWithID(key, key)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetWriteDeadline(deadline)
+	}
+
+	if _, err := io.Copy(stream, r); err != nil {
+		stream.Abort()
+		return err
+	}
+	return stream.Close()
+}
+
+func (g *GridFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	stream, err := g.bucket.OpenDownloadStream(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetReadDeadline(deadline)
+	}
+	return stream, nil
+}
+
+func (g *GridFS) Delete(ctx context.Context, key string) error {
+	err := g.bucket.DeleteContext(ctx, key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,20 @@
+// Package storage keeps uploaded media blobs. Metadata lives in the media
+// collection, a Storage only maps a key to the bytes.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned by Open and Delete for an unknown key
+var ErrNotFound = errors.New("blob not found")
+
+type Storage interface {
+	// Save stores everything read from r under key. Keys are fresh media IDs and never reused.
+	Save(ctx context.Context, key string, r io.Reader) error
+	// Open returns the blob stored under key. The caller must close it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
@@ -1,6 +1,6 @@
 import React, { useEffect, useState, useRef, useLayoutEffect } from "react";
 import { Link, useNavigate, useLocation } from "react-router-dom";
-import apiFetch from "../utils/apiFetch";
+import apiFetch, { refreshSession } from "../utils/apiFetch";
 
 export default function ChatPage() {
   const [profile, setProfile] = useState(null);
@@ -59,52 +59,74 @@ export default function ChatPage() {
     if (!profile) return;
 
     const wsUrl = `ws://localhost:8080/ws`;
-    const websocket = new WebSocket(wsUrl);
+    let websocket;
+    let closed = false;
 
-    websocket.onopen = () => {
-      console.log("✅ WebSocket connected");
-      setWs(websocket);
-      wsReady.current = true; // ✅ Mark as ready
-    };
+    const connect = (retried) => {
+      let opened = false;
+      websocket = new WebSocket(wsUrl);
 
-    websocket.onmessage = (event) => {
-      try {
-        const msg = JSON.parse(event.data);
-        if (
-          selectedChat &&
-          (msg.sender_id === selectedChat || msg.receiver_id === selectedChat)
-        ) {
-          setMessages((prev) => [...prev, msg]);
+      websocket.onopen = () => {
+        opened = true;
+        console.log("✅ WebSocket connected");
+        setWs(websocket);
+        wsReady.current = true; // ✅ Mark as ready
+      };
+
+      websocket.onmessage = (event) => {
+        try {
+          const evt = JSON.parse(event.data);
+          if (evt.type === "error") {
+            console.error("WebSocket error event", evt.payload);
+            return;
+          }
+          if (evt.type !== "message") return;
+
+          const msg = evt.payload;
+          if (
+            selectedChat &&
+            (msg.sender_id === selectedChat || msg.receiver_id === selectedChat)
+          ) {
+            setMessages((prev) => [...prev, msg]);
+          }
+
+          setConversations((prev) =>
+            prev.map((c) =>
+              c.user.id === msg.sender_id
+                ? {
+                    ...c,
+                    last_message: {
+                      content: msg.content,
+                      sender_id: msg.sender_id,
+                    },
+                  }
+                : c
+            )
+          );
+        } catch (err) {
+          console.error("Failed to parse WebSocket message", err);
         }
+      };
 
-        setConversations((prev) =>
-          prev.map((c) =>
-            c.user.id === msg.sender_id
-              ? {
-                  ...c,
-                  last_message: {
-                    content: msg.content,
-                    sender_id: msg.sender_id,
-                  },
-                }
-              : c
-          )
-        );
-      } catch (err) {
-        console.error("Failed to parse WebSocket message", err);
-      }
-    };
+      websocket.onerror = (err) => {
+        console.error("WebSocket error:", err);
+      };
 
-    websocket.onerror = (err) => {
-      console.error("WebSocket error:", err);
-    };
+      websocket.onclose = async () => {
+        console.log("WebSocket disconnected");
+        wsReady.current = false;
 
-    websocket.onclose = () => {
-      console.log("WebSocket disconnected");
-      wsReady.current = false;
+        // Upgrade ditolak sebelum terbuka, biasanya access token di cookie sudah expired
+        if (!opened && !retried && !closed && (await refreshSession()) && !closed) {
+          connect(true);
+        }
+      };
     };
 
+    connect(false);
+
     return () => {
+      closed = true;
       websocket.close();
       wsReady.current = false;
     };
@@ -176,7 +198,9 @@ export default function ChatPage() {
     };
 
     try {
-      ws.send(JSON.stringify(msgData));
+      ws.send(
+        JSON.stringify({ v: 1, type: "send_message", payload: msgData })
+      );
       console.log("✅ Sent via WS", msgData);
       setNewMessage("");
     } catch (err) {
@@ -5,66 +5,178 @@ import apiFetch from "../utils/apiFetch";
 
 export default function SearchUserPage() {
   const [search, setSearch] = useState("");
-  const [allUsers, setAllUsers] = useState([]);
-  const [filteredUsers, setFilteredUsers] = useState([]);
+  const [contacts, setContacts] = useState([]);
+  const [incoming, setIncoming] = useState([]);
+  const [messageRequests, setMessageRequests] = useState([]);
+  const [results, setResults] = useState([]);
   const [loading, setLoading] = useState(false);
   const navigate = useNavigate();
 
-  // 🔹 Load all users on mount
+  const term = search.trim();
+  // Server hanya mencari dari prefix username minimal 3 karakter
+  const searching = term.length >= 3;
+
+  // 🔹 Tanpa pencarian: kontak, permintaan kontak masuk dan message request
+  const loadInbox = async () => {
+    setLoading(true);
+    try {
+      const [usersData, incomingData, requestsData] = await Promise.all([
+        apiFetch("/api/v1/users?page=1&limit=100"),
+        apiFetch("/api/v1/contacts?status=incoming"),
+        apiFetch("/api/v1/chat/conversations?requests=true"),
+      ]);
+      setContacts(usersData.users || []);
+      setIncoming(incomingData.contacts || []);
+      setMessageRequests(requestsData.conversations || []);
+    } catch (err) {
+      console.error("Failed to load contacts:", err);
+      alert("Gagal memuat daftar kontak. Coba lagi.");
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  useEffect(() => {
+    loadInbox();
+  }, []);
+
+  // 🔹 Cari user lain di server, ditunda sedikit supaya tidak request tiap ketikan
   useEffect(() => {
-    const loadAllUsers = async () => {
+    if (!searching) {
+      setResults([]);
+      return;
+    }
+
+    const timer = setTimeout(async () => {
       setLoading(true);
       try {
-        const data = await apiFetch("/api/v1/users?page=1&limit=100");
-        
-        if (Array.isArray(data.users)) {
-          setAllUsers(data.users);
-          setFilteredUsers(data.users); // Show all by default
-        } else {
-          console.warn("Invalid response format:", data);
-          setAllUsers([]);
-          setFilteredUsers([]);
-        }
+        const data = await apiFetch(
+          `/api/v1/users?search=${encodeURIComponent(term)}&page=1&limit=50`
+        );
+        setResults(data.users || []);
       } catch (err) {
-        console.error("Failed to load users:", err);
-        alert("Gagal memuat daftar pengguna. Coba lagi.");
-        setAllUsers([]);
-        setFilteredUsers([]);
+        console.error("Failed to search users:", err);
+        setResults([]);
       } finally {
         setLoading(false);
       }
-    };
+    }, 300);
 
-    loadAllUsers();
-  }, []);
+    return () => clearTimeout(timer);
+  }, [term, searching]);
 
-  // 🔹 Filter users when search changes
-  useEffect(() => {
-    if (!Array.isArray(allUsers)) return;
+  const setResultStatus = (userId, status) => {
+    setResults((prev) =>
+      prev.map((u) => (u.id === userId ? { ...u, contact_status: status } : u))
+    );
+  };
 
-    const term = search.toLowerCase().trim();
+  const addContact = async (userId) => {
+    try {
+      const data = await apiFetch(`/api/v1/contacts/${userId}`, { method: "POST" });
+      setResultStatus(userId, data.status);
+      if (data.status === "accepted") loadInbox();
+    } catch (err) {
+      alert(err.message);
+    }
+  };
 
-    if (term === "") {
-      // Show all users if search is empty
-      setFilteredUsers(allUsers);
-      return;
+  const acceptContact = async (userId) => {
+    try {
+      await apiFetch(`/api/v1/contacts/${userId}/accept`, { method: "POST" });
+      setResultStatus(userId, "accepted");
+      loadInbox();
+    } catch (err) {
+      alert(err.message);
     }
+  };
 
-    // Filter users by username or email (safe check)
-    const filtered = allUsers.filter((user) => {
-      const username = user.username?.toLowerCase() || "";
-      const email = user.email?.toLowerCase() || "";
-      return username.includes(term) || email.includes(term);
-    });
+  const acceptRequest = async (userId) => {
+    try {
+      await apiFetch(`/api/v1/chat/requests/${userId}/accept`, { method: "POST" });
+      startChat(userId);
+    } catch (err) {
+      alert(err.message);
+    }
+  };
 
-    setFilteredUsers(filtered);
-  }, [search, allUsers]); // ✅ Depend on both
+  const declineRequest = async (userId) => {
+    try {
+      await apiFetch(`/api/v1/chat/requests/${userId}/decline`, { method: "POST" });
+      setMessageRequests((prev) => prev.filter((r) => r.user.id !== userId));
+    } catch (err) {
+      alert(err.message);
+    }
+  };
 
   // Start chat with selected user
   const startChat = (userId) => {
     navigate("/chat", { state: { selectedUser: userId } });
   };
 
+  const contactAction = (user) => {
+    switch (user.contact_status) {
+      case "accepted":
+        return null;
+      case "outgoing":
+        return <span className="text-xs text-gray-500">Menunggu</span>;
+      case "incoming":
+        return (
+          <button
+            onClick={(e) => {
+              e.stopPropagation();
+              acceptContact(user.id);
+            }}
+            className="text-xs px-3 py-1 rounded-full bg-blue-500 text-white hover:bg-blue-600"
+          >
+            Terima
+          </button>
+        );
+      default:
+        return (
+          <button
+            onClick={(e) => {
+              e.stopPropagation();
+              addContact(user.id);
+            }}
+            className="text-xs px-3 py-1 rounded-full border border-blue-500 text-blue-500 hover:bg-blue-50"
+          >
+            Tambah
+          </button>
+        );
+    }
+  };
+
+  const userRow = (user, subtitle, action) => (
+    <div
+      key={user.id}
+      onClick={() => startChat(user.id)}
+      className="flex items-center p-4 hover:bg-gray-50 border-b border-gray-100 cursor-pointer transition-colors"
+    >
+      <div className="relative mr-3">
+        <img
+          src={user.avatar || "https://placehold.co/40"}
+          alt={user.username}
+          className="w-10 h-10 rounded-full object-cover"
+        />
+        {user.online && (
+          <div className="absolute bottom-0 right-0 w-3 h-3 bg-green-500 border-2 border-white rounded-full"></div>
+        )}
+      </div>
+      <div className="flex-1 min-w-0">
+        <h3 className="text-sm font-semibold text-gray-900">{user.username}</h3>
+        <p className="text-sm text-gray-600 truncate">{subtitle}</p>
+      </div>
+      {action}
+    </div>
+  );
+
+  const sectionTitle = (title) => (
+    <div className="px-4 pt-4 pb-2 text-xs font-semibold text-gray-500 uppercase">
+      {title}
+    </div>
+  );
+
   return (
     <div className="flex flex-col h-screen bg-gray-50">
       {/* Header */}
@@ -96,7 +208,7 @@ export default function SearchUserPage() {
           </svg>
           <input
             type="text"
-            placeholder="Cari nama atau email..."
+            placeholder="Cari username..."
             value={search}
             onChange={(e) => setSearch(e.target.value)}
             className="bg-transparent flex-1 outline-none text-sm"
@@ -110,33 +222,70 @@ export default function SearchUserPage() {
           <div className="text-center py-4 text-gray-500">
             🔍 Memuat pengguna...
           </div>
-        ) : filteredUsers.length === 0 ? (
+        ) : term && !searching ? (
           <div className="text-center py-10 text-gray-500">
-            {search ? "Tidak ada pengguna ditemukan" : "Tidak ada pengguna"}
+            Ketik minimal 3 karakter username
           </div>
+        ) : searching ? (
+          results.length === 0 ? (
+            <div className="text-center py-10 text-gray-500">
+              Tidak ada pengguna ditemukan
+            </div>
+          ) : (
+            results.map((user) => userRow(user, user.bio, contactAction(user)))
+          )
         ) : (
-          filteredUsers.map((user) => (
-            <div
-              key={user.id}
-              onClick={() => startChat(user.id)}
-              className="flex items-center p-4 hover:bg-gray-50 border-b border-gray-100 cursor-pointer transition-colors"
-            >
-              <div className="relative mr-3">
-                <img
-                  src={user.avatar || "https://placehold.co/40"}
-                  alt={user.username}
-                  className="w-10 h-10 rounded-full object-cover"
-                />
-                {user.online && (
-                  <div className="absolute bottom-0 right-0 w-3 h-3 bg-green-500 border-2 border-white rounded-full"></div>
+          <>
+            {messageRequests.length > 0 && (
+              <>
+                {sectionTitle("Permintaan pesan")}
+                {messageRequests.map((r) =>
+                  userRow(
+                    r.user,
+                    r.last_message?.content,
+                    <div className="flex gap-2">
+                      <button
+                        onClick={(e) => {
+                          e.stopPropagation();
+                          acceptRequest(r.user.id);
+                        }}
+                        className="text-xs px-3 py-1 rounded-full bg-blue-500 text-white hover:bg-blue-600"
+                      >
+                        Terima
+                      </button>
+                      <button
+                        onClick={(e) => {
+                          e.stopPropagation();
+                          declineRequest(r.user.id);
+                        }}
+                        className="text-xs px-3 py-1 rounded-full border border-gray-300 text-gray-600 hover:bg-gray-100"
+                      >
+                        Tolak
+                      </button>
+                    </div>
+                  )
                 )}
+              </>
+            )}
+
+            {incoming.length > 0 && (
+              <>
+                {sectionTitle("Permintaan kontak")}
+                {incoming.map((c) =>
+                  userRow(c.user, c.user.bio, contactAction({ ...c.user, contact_status: "incoming" }))
+                )}
+              </>
+            )}
+
+            {sectionTitle("Kontak")}
+            {contacts.length === 0 ? (
+              <div className="text-center py-10 text-gray-500">
+                Belum ada kontak, cari username untuk menambahkan
               </div>
-              <div className="flex-1 min-w-0">
-                <h3 className="text-sm font-semibold text-gray-900">{user.username}</h3>
-                <p className="text-sm text-gray-600 truncate">{user.email}</p>
-              </div>
-            </div>
-          ))
+            ) : (
+              contacts.map((user) => userRow(user, user.bio, null))
+            )}
+          </>
         )}
       </div>
     </div>
@@ -1,7 +1,31 @@
 // utils/apiFetch.js
 
 const BASE_URL = "http://localhost:8080";
-const apiFetch = async (path, options = {}) => {
+
+// Access token cuma berlaku sebentar, cookie refresh_token dipakai untuk minta yang baru.
+// Request yang kena 401 bersamaan menunggu refresh yang sama: refresh token lama yang
+// dipakai dua kali dianggap dicuri dan server mencabut session-nya.
+let refreshing = null;
+
+export const refreshSession = () => {
+  if (!refreshing) {
+    refreshing = fetch(BASE_URL + "/api/v1/auth/refresh", {
+      method: "POST",
+      credentials: "include",
+    })
+      .then((res) => res.ok)
+      .catch(() => false)
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+};
+
+// Endpoint yang tidak butuh access token, 401 di sini bukan karena token expired
+const NO_REFRESH = ["/api/v1/auth/login", "/api/v1/auth/register", "/api/v1/auth/refresh"];
+
+const apiFetch = async (path, options = {}, retried = false) => {
   const config = {
     ...options,
     credentials: "include", // penting biar cookie JWT ikut
@@ -18,6 +42,13 @@ const apiFetch = async (path, options = {}) => {
 
     console.log("apiFetch: response status", res.status);
 
+    if (res.status === 401 && !retried && !NO_REFRESH.includes(path)) {
+      console.log("Unauthorized → refresh token");
+      if (await refreshSession()) {
+        return apiFetch(path, options, true);
+      }
+    }
+
     if (res.status === 401) {
       console.log("Unauthorized → clear session");
       localStorage.removeItem("user");